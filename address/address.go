@@ -0,0 +1,84 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package address
+
+import (
+	"csbench/config"
+	"csbench/utils"
+	"log"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// ListPublicIps lists the public IP addresses allocated to the accounts of the
+// domain, going through all the pages.
+func ListPublicIps(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.PublicIpAddress, error) {
+	ips, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.PublicIpAddress, int, error) {
+		p := cs.Address.NewListPublicIpAddressesParams()
+		p.SetDomainid(domainId)
+		p.SetAllocatedonly(true)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Address.ListPublicIpAddresses(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.PublicIpAddresses, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list public IP addresses due to %v", err)
+		return nil, err
+	}
+	return ips, nil
+}
+
+// AssociateIp acquires a public IP address for the network.
+func AssociateIp(cs *cloudstack.CloudStackClient, networkId string) (*cloudstack.AssociateIpAddressResponse, error) {
+	p := cs.Address.NewAssociateIpAddressParams()
+	p.SetNetworkid(networkId)
+	resp, err := cs.Address.AssociateIpAddress(p)
+	if err != nil {
+		log.Printf("Failed to acquire a public IP address for network %s due to: %v", networkId, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DisassociateIp releases the public IP address, along with its static NAT
+// and rules.
+func DisassociateIp(cs *cloudstack.CloudStackClient, ipId string) (bool, error) {
+	p := cs.Address.NewDisassociateIpAddressParams(ipId)
+	resp, err := cs.Address.DisassociateIpAddress(p)
+	if err != nil {
+		log.Printf("Failed to release public IP address with id %s due to %v", ipId, err)
+		return false, err
+	}
+	return resp.Success, nil
+}
+
+// EnableStaticNat maps the public IP address to the VM.
+func EnableStaticNat(cs *cloudstack.CloudStackClient, ipId string, vmId string) (bool, error) {
+	p := cs.NAT.NewEnableStaticNatParams(ipId, vmId)
+	resp, err := cs.NAT.EnableStaticNat(p)
+	if err != nil {
+		log.Printf("Failed to enable static NAT of public IP address %s to VM %s due to: %v", ipId, vmId, err)
+		return false, err
+	}
+	return resp.Success, nil
+}
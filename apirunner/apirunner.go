@@ -19,6 +19,7 @@ package apirunner
 
 import (
 	"bufio"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
@@ -30,20 +31,207 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"csbench/config"
+	"csbench/dashboard"
+	"csbench/failures"
+	"csbench/logger"
+	"csbench/utils"
+
 	log "github.com/sirupsen/logrus"
 )
 
-var processedAPImap = make(map[string]bool)
-var APIscount = 0
-var SuccessAPIs = 0
-var FailedAPIs = 0
-var TotalTime = 0.0
+const DefaultCommandsFile = "listCommands.txt"
+const DefaultReportDir = "report"
+const DefaultShutdownTimeout = 30 * time.Second
+
+// domainScopedCommands are the list APIs filtering by domainid, which are run
+// in each of the DomainLevels of a Runner.
+var domainScopedCommands = map[string]bool{
+	"listAccounts":          true,
+	"listUsers":             true,
+	"listVirtualMachines":   true,
+	"listVolumes":           true,
+	"listTemplates":         true,
+	"listIsos":              true,
+	"listNetworks":          true,
+	"listPublicIpAddresses": true,
+	"listVPCs":              true,
+	"listSnapshots":         true,
+	"listVMSnapshot":        true,
+	"listSecurityGroups":    true,
+	"listEvents":            true,
+	"listProjects":          true,
+}
+
+// taggedCommands are the list APIs of the resources csbench tags, which are
+// filtered by the Tags of a Runner.
+var taggedCommands = map[string]bool{
+	"listVirtualMachines":     true,
+	"listVolumes":             true,
+	"listNetworks":            true,
+	"listVPCs":                true,
+	"listPublicIpAddresses":   true,
+	"listFirewallRules":       true,
+	"listEgressFirewallRules": true,
+	"listPortForwardingRules": true,
+	"listLoadBalancerRules":   true,
+	"listSnapshots":           true,
+	"listVMSnapshot":          true,
+	"listTemplates":           true,
+	"listIsos":                true,
+}
+
+// DomainLevel is a domain of a domain tree, at a depth under the parent domain
+// starting at 1 for its children.
+type DomainLevel struct {
+	Level    int
+	DomainId string
+}
+
+// APIResult holds the timings of a benchmarked API for a profile and set of parameters.
+type APIResult struct {
+	Profile  string
+	Command  string
+	Page     int
+	PageSize int
+	Keyword  string
+	// DomainLevel is the level of the domain the API was scoped to, or 0.
+	DomainLevel int
+	Count       float64
+	MinTime     float64
+	MaxTime     float64
+	AvgTime     float64
+}
+
+// EndpointSummary holds the totals of the API calls sent to an endpoint.
+type EndpointSummary struct {
+	Name        string
+	URL         string
+	APIsCount   int
+	SuccessAPIs int
+	FailedAPIs  int
+	TotalTime   float64
+}
+
+// Summary holds the totals of all the API calls made by a Runner.
+type Summary struct {
+	APIsCount   int
+	SuccessAPIs int
+	FailedAPIs  int
+	TotalTime   float64
+	Failures    []*failures.Failure
+	Endpoints   []*EndpointSummary
+}
+
+/*
+Runner benchmarks the list APIs of a CloudStack management server. All the
+results and counters are kept in the Runner, so that several Runners can be
+used independently in the same process. A Runner is safe for concurrent use.
+*/
+type Runner struct {
+	APIURL string
+	// Endpoints, if set, are used in turn for every API call instead of the
+	// APIURL, which then only names the report directory.
+	Endpoints    []config.Endpoint
+	Iterations   int
+	Page         int
+	PageSize     int
+	DBProfile    int
+	CommandsFile string
+	ReportDir    string
+	Dashboard    *dashboard.Dashboard
+	// DomainLevels, if set, are the domains the domain-scoped commands are
+	// also run in, including their subdomains, with the timings of each level
+	// saved in a report named <command>-domainlevel<level>.csv.
+	DomainLevels []DomainLevel
+	// Tags, if set, filter the results of the list APIs of the resources
+	// csbench tags, e.g. to only list the resources created by a run.
+	Tags map[string]string
+
+	// ShutdownTimeout is how long an in-flight request is waited for once
+	// the context of RunAPIs is done, before it is aborted.
+	ShutdownTimeout time.Duration
+
+	mu            sync.Mutex
+	processedAPIs map[string]bool
+	apisCount     int
+	successAPIs   int
+	failedAPIs    int
+	totalTime     float64
+	results       []*APIResult
+	failures      *failures.Collector
+	endpoints     []*EndpointSummary
+	nextEndpoint  int
+}
+
+// NewRunner returns a Runner for the given API URL, reading the commands from
+// listCommands.txt and writing the CSV reports to the report directory.
+func NewRunner(apiURL string, iterations int, page int, pagesize int, dbProfile int) *Runner {
+	return &Runner{
+		APIURL:          apiURL,
+		Iterations:      iterations,
+		Page:            page,
+		PageSize:        pagesize,
+		DBProfile:       dbProfile,
+		CommandsFile:    DefaultCommandsFile,
+		ReportDir:       DefaultReportDir,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		processedAPIs:   make(map[string]bool),
+		failures:        &failures.Collector{},
+	}
+}
 
-func generateParams(apiKey string, secretKey string, signatureVersion int, expires int, command string, page int, pagesize int, keyword string) url.Values {
+// Summary returns the totals of the API calls made so far.
+func (r *Runner) Summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	endpoints := make([]*EndpointSummary, 0, len(r.endpoints))
+	for _, endpoint := range r.endpoints {
+		summary := *endpoint
+		endpoints = append(endpoints, &summary)
+	}
+	return Summary{
+		APIsCount:   r.apisCount,
+		SuccessAPIs: r.successAPIs,
+		FailedAPIs:  r.failedAPIs,
+		TotalTime:   r.totalTime,
+		Failures:    r.failures.Failures(),
+		Endpoints:   endpoints,
+	}
+}
+
+// endpoint returns the summary of the endpoint to send the next API call to.
+// It must be called with the lock held.
+func (r *Runner) endpoint() *EndpointSummary {
+	if r.endpoints == nil {
+		endpoints := r.Endpoints
+		if len(endpoints) == 0 {
+			parsedURL, _ := url.Parse(r.APIURL)
+			endpoints = []config.Endpoint{{Name: parsedURL.Hostname(), URL: r.APIURL}}
+		}
+		for _, endpoint := range endpoints {
+			r.endpoints = append(r.endpoints, &EndpointSummary{Name: endpoint.Name, URL: endpoint.URL})
+		}
+	}
+	endpoint := r.endpoints[r.nextEndpoint%len(r.endpoints)]
+	r.nextEndpoint++
+	return endpoint
+}
+
+// Results returns the timings of every API benchmarked so far.
+func (r *Runner) Results() []*APIResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*APIResult(nil), r.results...)
+}
+
+func generateParams(apiKey string, secretKey string, signatureVersion int, expires int, command string, page int, pagesize int, keyword string, domainId string, tags map[string]string) url.Values {
 	log.Info("Starting to generate parameters")
 	params := url.Values{}
 	params.Set("apiKey", apiKey)
@@ -56,6 +244,9 @@ func generateParams(apiKey string, secretKey string, signatureVersion int, expir
 	if command == "listTemplates" {
 		params.Set("templatefilter", "all")
 	}
+	if command == "listIsos" {
+		params.Set("isofilter", "all")
+	}
 
 	if page != 0 {
 		params.Set("page", strconv.Itoa(page))
@@ -66,6 +257,23 @@ func generateParams(apiKey string, secretKey string, signatureVersion int, expir
 		params.Set("keyword", keyword)
 	}
 
+	if domainId != "" {
+		params.Set("domainid", domainId)
+		params.Set("isrecursive", "true")
+	}
+
+	if taggedCommands[command] {
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			params.Set(fmt.Sprintf("tags[%d].key", i), key)
+			params.Set(fmt.Sprintf("tags[%d].value", i), tags[key])
+		}
+	}
+
 	// Generate and add the signature
 	signature := generateSignature(params.Encode(), secretKey)
 	params.Set("signature", signature)
@@ -73,62 +281,148 @@ func generateParams(apiKey string, secretKey string, signatureVersion int, expir
 	return params
 }
 
-func RunAPIs(profileName string, apiURL string, apiKey string, secretKey string, expires int, signatureVersion int, iterations int, page int, pagesize int, dbProfile int) {
-
-	log.Infof("Starting to run APIs from listCommands.txt file. Each command in the file will be run for multiple iterations and with page parameters mentioned in the configuration file.")
+/*
+RunAPIs runs every command of the commands file for the given profile. Once
+the context is done no new request is sent, the in-flight one is waited for up
+to the ShutdownTimeout, and the context error is returned. The run also stops
+on the first error writing the CSV reports, which is returned.
+*/
+func (r *Runner) RunAPIs(ctx context.Context, profileName string, apiKey string, secretKey string, expires int, signatureVersion int) error {
+	reqCtx, cancel := utils.WithGracePeriod(ctx, r.ShutdownTimeout)
+	defer cancel()
 
-	commandsFile := "listCommands.txt"
+	log.Infof("Starting to run APIs from %s file. Each command in the file will be run for multiple iterations and with page parameters mentioned in the configuration file.", r.CommandsFile)
 
 	// Read commands from file
-	commands, commandsKeywordMap, err := readCommandsFromFile(commandsFile)
+	commands, commandsKeywordMap, err := ReadCommandsFile(r.CommandsFile)
 	if err != nil {
 		log.Infof("Error reading commands from file: %s\n", err.Error())
-		return
+		return err
 	}
-	reportAppend := false
 	for _, command := range commands {
-		keyword := commandsKeywordMap[command]
-		if processedAPImap[command] {
-			reportAppend = true
+		calls := 1
+		if r.Page != 0 {
+			calls++
 		}
-		if page != 0 {
-			if iterations != 1 {
-				log.Infof("Calling API [%s] with page %d and pagesize %d -> ", command, page, pagesize)
+		if commandsKeywordMap[command] != "" {
+			calls++
+		}
+		if domainScopedCommands[command] {
+			calls += len(r.DomainLevels)
+		}
+		r.Dashboard.AddTotal(calls * r.Iterations)
+	}
+
+	for _, command := range commands {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		keyword := commandsKeywordMap[command]
+		reportAppend := r.isProcessed(command)
+		if r.Page != 0 {
+			if r.Iterations != 1 {
+				log.Infof("Calling API [%s] with page %d and pagesize %d -> ", command, r.Page, r.PageSize)
 			} else {
 				log.Infof("Calling API [%s] -> ", command)
 			}
 
-			params := generateParams(apiKey, secretKey, signatureVersion, expires, command, page, pagesize, "")
-			executeAPIandCalculate(profileName, apiURL, command, params, iterations, page, pagesize, "", dbProfile, reportAppend)
+			params := generateParams(apiKey, secretKey, signatureVersion, expires, command, r.Page, r.PageSize, "", "", r.Tags)
+			if err := r.executeAPIandCalculate(ctx, reqCtx, profileName, command, params, r.Page, r.PageSize, "", 0, reportAppend); err != nil {
+				return err
+			}
 			reportAppend = true
 		}
 
-		if len(keyword) != 0 || keyword != "" {
-			fmt.Printf("Calling API [%s] with keyword -> ", command)
-			params := generateParams(apiKey, secretKey, signatureVersion, expires, command, 0, 0, keyword)
-			executeAPIandCalculate(profileName, apiURL, command, params, iterations, 0, 0, keyword, dbProfile, reportAppend)
+		if (len(keyword) != 0 || keyword != "") && ctx.Err() == nil {
+			r.printf("Calling API [%s] with keyword -> ", command)
+			params := generateParams(apiKey, secretKey, signatureVersion, expires, command, 0, 0, keyword, "", r.Tags)
+			if err := r.executeAPIandCalculate(ctx, reqCtx, profileName, command, params, 0, 0, keyword, 0, reportAppend); err != nil {
+				return err
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.printf("Calling API [%s] -> ", command)
+		params := generateParams(apiKey, secretKey, signatureVersion, expires, command, 0, 0, "", "", r.Tags)
+		if err := r.executeAPIandCalculate(ctx, reqCtx, profileName, command, params, 0, 0, "", 0, reportAppend); err != nil {
+			return err
+		}
+
+		if domainScopedCommands[command] {
+			for _, level := range r.DomainLevels {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				r.printf("Calling API [%s] in a domain at level %d -> ", command, level.Level)
+				params := generateParams(apiKey, secretKey, signatureVersion, expires, command, 0, 0, "", level.DomainId, r.Tags)
+				name := reportName(command, level.Level)
+				if err := r.executeAPIandCalculate(ctx, reqCtx, profileName, command, params, 0, 0, "", level.Level, r.isProcessed(name)); err != nil {
+					return err
+				}
+				r.markProcessed(name)
+			}
 		}
 
-		fmt.Printf("Calling API [%s] -> ", command)
-		params := generateParams(apiKey, secretKey, signatureVersion, expires, command, 0, 0, "")
-		executeAPIandCalculate(profileName, apiURL, command, params, iterations, 0, 0, "", dbProfile, reportAppend)
+		r.printf("------------------------------------------------------------\n")
+		r.markProcessed(command)
+	}
+	return ctx.Err()
+}
 
-		fmt.Printf("------------------------------------------------------------\n")
-		processedAPImap[command] = true
+// reportName returns the name of the report of a command scoped to a domain level, if any.
+func reportName(command string, domainLevel int) string {
+	if domainLevel == 0 {
+		return command
 	}
+	return fmt.Sprintf("%s-domainlevel%d", command, domainLevel)
+}
+
+func (r *Runner) isProcessed(command string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.processedAPIs[command]
+}
+
+func (r *Runner) markProcessed(command string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.processedAPIs[command] = true
 }
 
-func executeAPIandCalculate(profileName string, apiURL string, command string, params url.Values, iterations int, page int, pagesize int, keyword string, dbProfile int, reportAppend bool) {
+// printf writes progress to stdout unless the live dashboard owns the terminal.
+func (r *Runner) printf(format string, a ...interface{}) {
+	if r.Dashboard == nil {
+		fmt.Printf(format, a...)
+	}
+}
+
+/*
+executeAPIandCalculate runs the API for the configured number of iterations
+and saves the timings, returning the error writing the reports, if any. When
+the context is done, the remaining iterations are skipped and the timings of
+the completed ones are saved, if any.
+*/
+func (r *Runner) executeAPIandCalculate(ctx context.Context, reqCtx context.Context, profileName string, command string, params url.Values, page int, pagesize int, keyword string, domainLevel int, reportAppend bool) error {
 	var minTime = math.MaxFloat64
 	var maxTime = 0.0
 	var avgTime float64
 	var totalTime float64
 	var count float64
-	if iterations != 1 {
-		log.Infof("Calling API %s for %d number of iterations with parameters %s", command, iterations, params)
-		for i := 1; i <= iterations; i++ {
+	if r.Iterations != 1 {
+		log.Infof("Calling API %s for %d number of iterations with parameters %s", command, r.Iterations, maskParams(params))
+		iterations := 0
+		for i := 1; i <= r.Iterations; i++ {
+			if ctx.Err() != nil {
+				break
+			}
 			log.Infof("Started with iteration %d for the command %s", i, command)
-			elapsedTime, apicount, result := executeAPI(apiURL, params)
+			elapsedTime, apicount, result, aborted := r.executeAPI(reqCtx, params)
+			if aborted {
+				break
+			}
+			iterations = i
 			count = apicount
 			if elapsedTime < minTime {
 				minTime = elapsedTime
@@ -141,35 +435,66 @@ func executeAPIandCalculate(profileName string, apiURL string, command string, p
 				break
 			}
 		}
-		avgTime = totalTime / float64(iterations)
+		if iterations == 0 {
+			return nil
+		}
+		if iterations < r.Iterations && ctx.Err() != nil {
+			log.Warnf("Interrupted after %d of %d iterations for the command %s", iterations, r.Iterations, command)
+			avgTime = totalTime / float64(iterations)
+		} else {
+			avgTime = totalTime / float64(r.Iterations)
+		}
 		log.Infof("count [%.f] : Time in seconds [Min - %.2f] [Max - %.2f] [Avg - %.2f]\n", count, minTime, maxTime, avgTime)
-		saveData(apiURL, count, minTime, maxTime, avgTime, page, pagesize, keyword, profileName, command, dbProfile, reportAppend)
 	} else {
-		elapsedTime, apicount, _ := executeAPI(apiURL, params)
+		elapsedTime, apicount, _, aborted := r.executeAPI(reqCtx, params)
+		if aborted {
+			return nil
+		}
 		log.Infof("Elapsed time [%.2f seconds] for the count [%.0f]", elapsedTime, apicount)
-		saveData(apiURL, count, elapsedTime, elapsedTime, elapsedTime, page, pagesize, keyword, profileName, command, dbProfile, reportAppend)
+		count = apicount
+		minTime, maxTime, avgTime = elapsedTime, elapsedTime, elapsedTime
 	}
+
+	r.mu.Lock()
+	r.results = append(r.results, &APIResult{
+		Profile:     profileName,
+		Command:     command,
+		Page:        page,
+		PageSize:    pagesize,
+		Keyword:     keyword,
+		DomainLevel: domainLevel,
+		Count:       count,
+		MinTime:     minTime,
+		MaxTime:     maxTime,
+		AvgTime:     avgTime,
+	})
+	r.mu.Unlock()
+	return r.saveData(count, minTime, maxTime, avgTime, page, pagesize, keyword, profileName, reportName(command, domainLevel), reportAppend)
 }
 
-func saveData(apiURL string, count float64, minTime float64, maxTime float64, avgTime float64, page int, pageSize int, keyword string, user string, filename string, dbProfile int, reportAppend bool) {
+/*
+saveData appends the timings of a command to its individual and accumulated
+CSV reports. Errors are returned rather than logged, as a run whose reports
+can't be written has to stop.
+*/
+func (r *Runner) saveData(count float64, minTime float64, maxTime float64, avgTime float64, page int, pageSize int, keyword string, user string, filename string, reportAppend bool) error {
+	apiURL := r.APIURL
+	dbProfile := r.DBProfile
 
 	parsedURL, err := url.Parse(apiURL)
 	if err != nil {
-		log.Infof("Error parsing URL : %s with error : %s\n", apiURL, err)
-		return
+		return fmt.Errorf("error parsing URL %s: %w", apiURL, err)
 	}
 	host := parsedURL.Hostname()
 
-	err = os.MkdirAll(fmt.Sprintf("report/accumulated/%s", host), 0755)
+	err = os.MkdirAll(fmt.Sprintf("%s/accumulated/%s", r.ReportDir, host), 0755)
 	if err != nil {
-		log.Infof("Error creating host directory : report/accumulated/%s\n", host, err)
-		return
+		return fmt.Errorf("error creating host directory %s/accumulated/%s: %w", r.ReportDir, host, err)
 	}
 
-	err = os.MkdirAll(fmt.Sprintf("report/individual/%s", host), 0755)
+	err = os.MkdirAll(fmt.Sprintf("%s/individual/%s", r.ReportDir, host), 0755)
 	if err != nil {
-		log.Infof("Error creating host directory : report/individual/%s\n", host, err)
-		return
+		return fmt.Errorf("error creating host directory %s/individual/%s: %w", r.ReportDir, host, err)
 	}
 
 	fileMode := os.O_WRONLY | os.O_CREATE
@@ -179,15 +504,15 @@ func saveData(apiURL string, count float64, minTime float64, maxTime float64, av
 		fileMode |= os.O_TRUNC
 	}
 
-	individualFile, err := os.OpenFile(fmt.Sprintf("report/individual/%s/%s.csv", host, filename), fileMode, 0644)
+	individualFile, err := os.OpenFile(fmt.Sprintf("%s/individual/%s/%s.csv", r.ReportDir, host, filename), fileMode, 0644)
 	if err != nil {
-		log.Fatalf("Error opening the file CSV : report/individual/%s/%s.csv with error %s\n", host, filename, apiURL, err)
+		return fmt.Errorf("error opening the CSV file %s/individual/%s/%s.csv: %w", r.ReportDir, host, filename, err)
 	}
 	defer individualFile.Close()
 
-	accumulatedFile, err := os.OpenFile(fmt.Sprintf("report/accumulated/%s/%s.csv", host, filename), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	accumulatedFile, err := os.OpenFile(fmt.Sprintf("%s/accumulated/%s/%s.csv", r.ReportDir, host, filename), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
-		log.Fatalf("Error opening the file CSV : report/accumulated/%s/%s.csv with error %s\n", host, filename, apiURL, err)
+		return fmt.Errorf("error opening the CSV file %s/accumulated/%s/%s.csv: %w", r.ReportDir, host, filename, err)
 	}
 	defer accumulatedFile.Close()
 
@@ -198,7 +523,7 @@ func saveData(apiURL string, count float64, minTime float64, maxTime float64, av
 
 		filereader, err := os.Open(file.Name())
 		if err != nil {
-			log.Fatalf("Error opening the file CSV : %s with error %s\n", file.Name(), err)
+			return fmt.Errorf("error opening the CSV file %s: %w", file.Name(), err)
 		}
 		defer filereader.Close()
 		reader := bufio.NewReader(filereader)
@@ -209,8 +534,7 @@ func saveData(apiURL string, count float64, minTime float64, maxTime float64, av
 			header := []string{"Count", "MinTime", "MaxTime", "AvgTime", "Page", "PageSize", "keyword", "User", "DBprofile"}
 			err = writer.Write(header)
 			if err != nil {
-				log.Infof("Error writing CSV header for the API: %s with error %s\n", apiURL, err)
-				return
+				return fmt.Errorf("error writing the CSV header to %s: %w", file.Name(), err)
 			}
 		}
 
@@ -242,44 +566,83 @@ func saveData(apiURL string, count float64, minTime float64, maxTime float64, av
 		}
 		err = writer.Write(record)
 		if err != nil {
-			log.Infof("Error writing to CSV for the API: %s with error %s\n", apiURL, err)
-			return
+			return fmt.Errorf("error writing to the CSV file %s: %w", file.Name(), err)
 		}
 	}
 
-	message := fmt.Sprintf("Data saved to report/%s/%s.csv successfully.\n", host, filename)
+	message := fmt.Sprintf("Data saved to %s/%s/%s.csv successfully.\n", r.ReportDir, host, filename)
 	log.Info(message)
+	return nil
 }
 
-func executeAPI(apiURL string, params url.Values) (float64, float64, bool) {
-	// Send the API request and calculate the time
-	apiURL = fmt.Sprintf("%s?%s", apiURL, params.Encode())
+// executeAPI runs the API and records its outcome. Requests aborted because
+// ctx is done aren't recorded, and are reported as such.
+func (r *Runner) executeAPI(ctx context.Context, params url.Values) (float64, float64, bool, bool) {
+	command := params.Get("command")
+	r.mu.Lock()
+	endpoint := r.endpoint()
+	r.mu.Unlock()
+
+	r.Dashboard.Begin(command)
+	elapsed, count, failure := doExecuteAPI(ctx, endpoint.URL, params)
+	if failure != nil && ctx.Err() != nil {
+		r.Dashboard.End(command, elapsed, false)
+		return elapsed, count, false, true
+	}
+
+	r.mu.Lock()
+	r.apisCount++
+	r.totalTime += elapsed
+	endpoint.APIsCount++
+	endpoint.TotalTime += elapsed
+	if failure != nil {
+		r.failedAPIs++
+		endpoint.FailedAPIs++
+	} else {
+		r.successAPIs++
+		endpoint.SuccessAPIs++
+	}
+	r.mu.Unlock()
+	r.failures.Add(failure)
+
+	r.Dashboard.End(command, elapsed, failure == nil)
+	return elapsed, count, failure == nil, false
+}
+
+func doExecuteAPI(ctx context.Context, apiURL string, params url.Values) (float64, float64, *failures.Failure) {
+	command := params.Get("command")
+	// Send the API request and calculate the time. The logged URL has the
+	// API key and the signature masked.
+	requestURL := fmt.Sprintf("%s?%s", apiURL, params.Encode())
+	apiURL = fmt.Sprintf("%s?%s", apiURL, maskParams(params).Encode())
 	log.Infof("Running the API %s", apiURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		errorText := strings.ReplaceAll(err.Error(), requestURL, apiURL)
+		log.Infof("Error creating API request: %s with error %s\n", apiURL, errorText)
+		return 0, 0, failures.New(command, 0, 0, 0, errorText)
+	}
 	start := time.Now()
-	resp, err := http.Get(apiURL)
-	APIscount++
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Infof("Error sending API request: %s with error %s\n", apiURL, err)
-		FailedAPIs++
-		return 0, 0, false
+		errorText := strings.ReplaceAll(err.Error(), requestURL, apiURL)
+		log.Infof("Error sending API request: %s with error %s\n", apiURL, errorText)
+		return 0, 0, failures.New(command, 0, 0, 0, errorText)
 	}
 	defer resp.Body.Close()
 	elapsed := time.Since(start)
-	TotalTime += elapsed.Seconds()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Infof("Error reading API response: %s with error %s\n", apiURL, err)
-		FailedAPIs++
-		return 0, 0, false
+		return 0, 0, failures.New(command, resp.StatusCode, 0, 0, err.Error())
 	}
 
 	var data map[string]interface{}
 	err = json.Unmarshal([]byte(body), &data)
 	if err != nil {
 		log.Infof("Error parsing JSON for the API response: %s with error %s\n", apiURL, err)
-		FailedAPIs++
-		return 0, 0, false
+		return 0, 0, failures.New(command, resp.StatusCode, 0, 0, err.Error())
 	}
 	var key string
 	for k := range data {
@@ -291,15 +654,28 @@ func executeAPI(apiURL string, params url.Values) (float64, float64, bool) {
 	if !ok {
 		errorCode, ok := data[key].(map[string]interface{})["errorcode"].(float64)
 		if ok {
-			errorText := data[key].(map[string]interface{})["errortext"].(string)
+			csErrorCode, _ := data[key].(map[string]interface{})["cserrorcode"].(float64)
+			errorText, _ := data[key].(map[string]interface{})["errortext"].(string)
 			log.Infof(" [Error] while calling the API ErrorCode[%.0f] ErrorText[%s]", errorCode, errorText)
-			FailedAPIs++
-			return elapsed.Seconds(), count, false
+			return elapsed.Seconds(), count, failures.New(command, resp.StatusCode, int(errorCode), int(csErrorCode), errorText)
 		}
 	}
 
-	SuccessAPIs++
-	return elapsed.Seconds(), count, true
+	return elapsed.Seconds(), count, nil
+}
+
+// maskParams returns a copy of the request parameters with the credentials masked, for logging.
+func maskParams(params url.Values) url.Values {
+	masked := url.Values{}
+	for key, values := range params {
+		masked[key] = values
+	}
+	for _, key := range []string{"apiKey", "signature"} {
+		if masked.Has(key) {
+			masked.Set(key, logger.Mask)
+		}
+	}
+	return masked
 }
 
 func generateSignature(unsignedRequest string, secretKey string) string {
@@ -313,7 +689,9 @@ func generateSignature(unsignedRequest string, secretKey string) string {
 	return computedSignature
 }
 
-func readCommandsFromFile(filename string) ([]string, map[string]string, error) {
+// ReadCommandsFile returns the commands listed in a commands file, and the
+// keyword to search for with each command, if any.
+func ReadCommandsFile(filename string) ([]string, map[string]string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,124 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"fmt"
+	"time"
+
+	"csbench/config"
+	"csbench/dashboard"
+	"csbench/failures"
+)
+
+// Config is the configuration of a CloudStack environment to benchmark.
+type Config = config.Config
+
+// ConfigFromFile reads and validates the configuration from a csbench config file.
+func ConfigFromFile(path string) (*Config, error) {
+	return config.ReadConfig(path)
+}
+
+// Result is the outcome of a single resource operation.
+type Result struct {
+	Success  bool
+	Duration float64
+	Failure  *failures.Failure
+	// Endpoint is the name of the management server endpoint the operation was sent to.
+	Endpoint string
+	// Type is the resource type the result is reported under, if not the
+	// stage which ran the operation.
+	Type string
+}
+
+// newResult returns the result of an operation started at start, which failed
+// calling api if err is not nil.
+func newResult(start time.Time, api string, err error) *Result {
+	return &Result{
+		Success:  err == nil,
+		Duration: time.Since(start).Seconds(),
+		Failure:  failures.FromError(api, err),
+	}
+}
+
+// Results holds the results of the resource operations, keyed by resource type.
+type Results map[string][]*Result
+
+// Failures returns the failures of all the operations.
+func (r Results) Failures() []*failures.Failure {
+	var errors []*failures.Failure
+	for _, results := range r {
+		for _, result := range results {
+			if result.Failure != nil {
+				errors = append(errors, result.Failure)
+			}
+		}
+	}
+	return errors
+}
+
+// DefaultShutdownTimeout is how long in-flight operations are waited for once a run is cancelled.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Bench runs benchmarks against a CloudStack environment.
+type Bench struct {
+	cfg *Config
+
+	// Dashboard, if set, is updated with the progress of every operation.
+	Dashboard *dashboard.Dashboard
+
+	// ShutdownTimeout is how long in-flight operations are waited for once
+	// the context of a run is done, before they are aborted.
+	ShutdownTimeout time.Duration
+
+	// StateFile, if set, is where Create saves the progress of its stages,
+	// to skip the ones already completed when it is run again.
+	StateFile string
+
+	// InventoryFile, if set, is where every resource created is recorded,
+	// for TearDown to delete exactly those.
+	InventoryFile string
+}
+
+// New returns a Bench for a copy of the given configuration, so that Benches
+// built from the same configuration do not share their run. Configurations
+// built in code, e.g. starting from config.New, should be checked with Validate
+// first. The RunId of the copy defaults to the start time of the run followed
+// by a random suffix.
+func New(cfg *Config) *Bench {
+	c := *cfg
+	c.Profiles = append([]*config.Profile(nil), cfg.Profiles...)
+	if c.RunId == "" {
+		c.RunId = newRunId()
+	}
+	return &Bench{cfg: &c, ShutdownTimeout: DefaultShutdownTimeout}
+}
+
+// Config returns the configuration of the Bench, with its RunId and the IDs
+// resolved by Discover.
+func (b *Bench) Config() *Config {
+	return b.cfg
+}
+
+func (b *Bench) adminProfile() (*config.Profile, error) {
+	profile := b.cfg.Profile("admin")
+	if profile == nil {
+		return nil, fmt.Errorf("no admin profile found in the configuration")
+	}
+	return profile, nil
+}
@@ -0,0 +1,221 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"context"
+	"fmt"
+
+	"csbench/apirunner"
+	"csbench/config"
+	"csbench/domain"
+	"csbench/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Scenario describes a benchmark run of the list APIs.
+type Scenario struct {
+	// Profiles are the names of the profiles to run the APIs as. All the profiles are used if empty.
+	Profiles []string
+	// DBProfile is recorded in the reports to identify the database used.
+	DBProfile int
+	// CommandsFile lists the APIs to run, defaults to listCommands.txt.
+	CommandsFile string
+	// ReportDir is where the CSV reports are written, defaults to report.
+	ReportDir string
+	// DomainLevels also runs the domain-scoped APIs in a domain at each level
+	// of the domain tree under the parent domain.
+	DomainLevels bool
+	// RunId, if set, filters the list APIs of the VMs, volumes and networks
+	// on the tag of the run, to only list the resources it created.
+	RunId string
+}
+
+// BenchmarkResult holds the outcome of a benchmark run.
+type BenchmarkResult struct {
+	Summary apirunner.Summary
+	APIs    []*apirunner.APIResult
+	// Interrupted is set when the run was cancelled and the results are partial.
+	Interrupted bool
+}
+
+/*
+Benchmark runs the APIs of the scenario for each of its profiles. In the each
+endpoint mode the whole scenario is run against every endpoint in turn, with
+the CSV reports written per endpoint, and in the distribute mode the API calls
+are sent to the endpoints in turn. The summary is broken down per endpoint.
+
+Once the context is done no new API call is made, the in-flight one is waited
+for up to the ShutdownTimeout, and the partial results are returned along with
+the context error. Likewise, an error writing the CSV reports stops the run and
+is returned along with the partial results.
+*/
+func (b *Bench) Benchmark(ctx context.Context, scenario Scenario) (*BenchmarkResult, error) {
+	profiles := b.cfg.Profiles
+	if len(scenario.Profiles) > 0 {
+		profiles = nil
+		for _, name := range scenario.Profiles {
+			profile := b.cfg.Profile(name)
+			if profile == nil {
+				return nil, fmt.Errorf("profile %s not found in the configuration", name)
+			}
+			profiles = append(profiles, profile)
+		}
+	}
+
+	var levels []apirunner.DomainLevel
+	if scenario.DomainLevels {
+		var err error
+		if levels, err = b.domainLevels(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var runners []*apirunner.Runner
+	switch b.cfg.EndpointMode {
+	case config.EndpointModeEach:
+		for _, endpoint := range b.cfg.ActiveEndpoints() {
+			runners = append(runners, b.newRunner(endpoint.URL, scenario))
+		}
+	case config.EndpointModeDistribute:
+		runner := b.newRunner(b.cfg.URL, scenario)
+		runner.Endpoints = b.cfg.ActiveEndpoints()
+		runners = append(runners, runner)
+	default:
+		runners = append(runners, b.newRunner(b.cfg.URL, scenario))
+	}
+
+	result := &BenchmarkResult{}
+	var err error
+	for _, runner := range runners {
+		err = b.runProfiles(ctx, runner, profiles, levels)
+		summary := runner.Summary()
+		result.Summary.APIsCount += summary.APIsCount
+		result.Summary.SuccessAPIs += summary.SuccessAPIs
+		result.Summary.FailedAPIs += summary.FailedAPIs
+		result.Summary.TotalTime += summary.TotalTime
+		result.Summary.Failures = append(result.Summary.Failures, summary.Failures...)
+		result.Summary.Endpoints = append(result.Summary.Endpoints, summary.Endpoints...)
+		result.APIs = append(result.APIs, runner.Results()...)
+		if err != nil {
+			break
+		}
+	}
+	result.Interrupted = ctx.Err() != nil
+
+	return result, err
+}
+
+func (b *Bench) newRunner(apiURL string, scenario Scenario) *apirunner.Runner {
+	runner := apirunner.NewRunner(apiURL, b.cfg.Iterations, b.cfg.Page, b.cfg.PageSize, scenario.DBProfile)
+	runner.Dashboard = b.Dashboard
+	runner.ShutdownTimeout = b.ShutdownTimeout
+	if scenario.CommandsFile != "" {
+		runner.CommandsFile = scenario.CommandsFile
+	}
+	if scenario.ReportDir != "" {
+		runner.ReportDir = scenario.ReportDir
+	}
+	if scenario.RunId != "" {
+		runner.Tags = map[string]string{config.TagRunId: scenario.RunId}
+	}
+	return runner
+}
+
+func (b *Bench) runProfiles(ctx context.Context, runner *apirunner.Runner, profiles []*config.Profile, levels []apirunner.DomainLevel) error {
+	for i, profile := range profiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		runner.DomainLevels = visibleDomainLevels(ctx, runner.APIURL, profile, levels)
+		log.Infof("Using profile %d.%s for benchmarking %s", i+1, profile.Name, runner.APIURL)
+		if b.Dashboard == nil {
+			fmt.Printf("\n\033[1;34m============================================================\033[0m\n")
+			fmt.Printf("                    Profile: [%s]\n", profile.Name)
+			fmt.Printf("\033[1;34m============================================================\033[0m\n")
+		}
+		if err := runner.RunAPIs(ctx, profile.Name, profile.ApiKey, profile.SecretKey, profile.Expires, profile.SignatureVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+domainLevels returns the first domain found at each level of the domain tree
+under the parent domain, listed using the admin profile. The levels start at 1
+for the children of the parent domain.
+*/
+func (b *Bench) domainLevels(ctx context.Context) ([]apirunner.DomainLevel, error) {
+	profile, err := b.adminProfile()
+	if err != nil {
+		return nil, err
+	}
+	cs := utils.NewAsyncClient(ctx, b.cfg.URL, profile.ApiKey, profile.SecretKey)
+	subdomains, err := domain.ListAllSubDomains(cs, b.cfg, b.cfg.ParentDomainId)
+	if err != nil {
+		return nil, fmt.Errorf("error listing the subdomains of the parent domain %s: %w", b.cfg.ParentDomainId, err)
+	}
+	if len(subdomains) == 0 {
+		return nil, fmt.Errorf("no subdomains found under the parent domain %s", b.cfg.ParentDomainId)
+	}
+
+	top := subdomains[0].Level
+	for _, subdomain := range subdomains {
+		if subdomain.Level < top {
+			top = subdomain.Level
+		}
+	}
+	byLevel := make(map[int]string)
+	for _, subdomain := range subdomains {
+		level := subdomain.Level - top + 1
+		if _, ok := byLevel[level]; !ok {
+			byLevel[level] = subdomain.Id
+		}
+	}
+	levels := make([]apirunner.DomainLevel, 0, len(byLevel))
+	for level := 1; level <= len(byLevel); level++ {
+		levels = append(levels, apirunner.DomainLevel{Level: level, DomainId: byLevel[level]})
+	}
+	log.Infof("Found a domain tree of %d levels under the parent domain %s", len(levels), b.cfg.ParentDomainId)
+	return levels, nil
+}
+
+// visibleDomainLevels returns the levels whose domain the profile can access,
+// as a user account cannot list the resources of other domains.
+func visibleDomainLevels(ctx context.Context, apiURL string, profile *config.Profile, levels []apirunner.DomainLevel) []apirunner.DomainLevel {
+	if len(levels) == 0 {
+		return nil
+	}
+	cs := utils.NewAsyncClient(ctx, apiURL, profile.ApiKey, profile.SecretKey)
+	var visible []apirunner.DomainLevel
+	for _, level := range levels {
+		p := cs.Domain.NewListDomainsParams()
+		p.SetId(level.DomainId)
+		resp, err := cs.Domain.ListDomains(p)
+		if err != nil || resp.Count == 0 {
+			continue
+		}
+		visible = append(visible, level)
+	}
+	if len(visible) < len(levels) {
+		log.Infof("Profile %s can access %d of the %d domain levels", profile.Name, len(visible), len(levels))
+	}
+	return visible
+}
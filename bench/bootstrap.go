@@ -0,0 +1,94 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"context"
+	"fmt"
+
+	"csbench/config"
+	"csbench/domain"
+	"csbench/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The profiles created by Bootstrap and the type of their accounts.
+var bootstrapProfiles = []struct {
+	name        string
+	accountType int
+}{
+	{"user", domain.AccountTypeUser},
+	{"domainadmin", domain.AccountTypeDomainAdmin},
+}
+
+/*
+Bootstrap creates, using the admin profile, a user account and a domain admin
+account in the parent domain, registers API keys for their users, and returns
+the user and domainadmin profiles holding those keys. The profiles use the
+default expires, signatureversion and timeout. The accounts are recorded in the
+inventory, so that TearDown deletes them along with the domain stage.
+
+On failure the profiles of the accounts created so far are returned along with
+the error, so that their keys can still be saved.
+*/
+func (b *Bench) Bootstrap(ctx context.Context) ([]*config.Profile, error) {
+	profile, err := b.adminProfile()
+	if err != nil {
+		return nil, err
+	}
+	if b.cfg.ParentDomainId == "" {
+		return nil, fmt.Errorf("parentdomainid must be set to bootstrap the accounts")
+	}
+
+	graceCtx, cancel := utils.WithGracePeriod(ctx, b.ShutdownTimeout)
+	defer cancel()
+	cs := utils.NewAsyncClient(graceCtx, b.cfg.URL, profile.ApiKey, profile.SecretKey)
+
+	inv, err := b.openInventory()
+	if err != nil {
+		return nil, err
+	}
+	defer inv.Close()
+
+	var profiles []*config.Profile
+	for _, bootstrap := range bootstrapProfiles {
+		if err := ctx.Err(); err != nil {
+			return profiles, err
+		}
+		account, err := domain.CreateAccount(cs, b.cfg, b.cfg.ParentDomainId, bootstrap.accountType)
+		if err != nil {
+			return profiles, fmt.Errorf("error creating the %s account: %w", bootstrap.name, err)
+		}
+		inv.add(ResourceAccount, account.Id, account.Name, b.cfg.ParentDomainId)
+		if len(account.User) == 0 {
+			return profiles, fmt.Errorf("no user created for the %s account %s", bootstrap.name, account.Name)
+		}
+		keys, err := domain.RegisterUserKeys(cs, account.User[0].Id)
+		if err != nil {
+			return profiles, fmt.Errorf("error registering the keys of the %s account %s: %w", bootstrap.name, account.Name, err)
+		}
+		log.Infof("Created the %s account %s", bootstrap.name, account.Name)
+
+		p := config.NewProfile(bootstrap.name)
+		p.ApiKey = keys.Apikey
+		p.SecretKey = keys.Secretkey
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
@@ -0,0 +1,71 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"context"
+	"sync/atomic"
+
+	"csbench/config"
+	"csbench/utils"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// endpointClient is a CloudStack client for a management server endpoint.
+type endpointClient struct {
+	endpoint config.Endpoint
+	cs       *cloudstack.CloudStackClient
+}
+
+// endpointClients hands out the clients of a set of endpoints in a round-robin fashion.
+type endpointClients struct {
+	clients []endpointClient
+	next    atomic.Uint64
+}
+
+func newEndpointClients(ctx context.Context, endpoints []config.Endpoint, profile *config.Profile) *endpointClients {
+	clients := &endpointClients{}
+	for _, endpoint := range endpoints {
+		clients.clients = append(clients.clients, endpointClient{
+			endpoint: endpoint,
+			cs:       utils.NewAsyncClient(ctx, endpoint.URL, profile.ApiKey, profile.SecretKey),
+		})
+	}
+	return clients
+}
+
+// primary returns the client of the first endpoint.
+func (c *endpointClients) primary() *cloudstack.CloudStackClient {
+	return c.clients[0].cs
+}
+
+// Next returns the client of the next endpoint.
+func (c *endpointClients) Next() endpointClient {
+	n := c.next.Add(1) - 1
+	return c.clients[n%uint64(len(c.clients))]
+}
+
+// createEndpoints returns the endpoints to create resources through: the
+// endpoints in the distribute mode, and the url otherwise.
+func createEndpoints(cfg *config.Config) []config.Endpoint {
+	if cfg.EndpointMode == config.EndpointModeDistribute {
+		return cfg.ActiveEndpoints()
+	}
+	return []config.Endpoint{{Name: cfg.Host(), URL: cfg.URL}}
+}
@@ -0,0 +1,2037 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"csbench/address"
+	"csbench/config"
+	"csbench/dashboard"
+	"csbench/domain"
+	"csbench/image"
+	"csbench/network"
+	"csbench/rules"
+	"csbench/snapshot"
+	"csbench/tags"
+	"csbench/utils"
+	"csbench/vm"
+	"csbench/volume"
+	"csbench/vpc"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	log "github.com/sirupsen/logrus"
+)
+
+// Stages selects the resources to create.
+type Stages struct {
+	Domain    bool
+	Limits    bool
+	Network   bool
+	Vpc       bool
+	Isolated  bool
+	Vm        bool
+	StaticNat bool
+	Rules     bool
+	Volume    bool
+	Snapshot  bool
+	Images    bool
+}
+
+/*
+Create converges the environment under the parent domain to the selected
+resources using the admin profile, running up to workers operations in
+parallel, and returns the results of every operation keyed by the resource
+type. The stages are run in the order domain, limits, network, vpc, isolated,
+vm, staticnat, rules, volume, snapshot, images.
+
+The existing resources are counted first and only the ones missing are
+created, so running Create again resumes a run which failed or was
+interrupted. Only the subdomains csbench created, whose names start with the
+nameprefix, are counted and converged, and no resource is created in the
+domains others created under the parent domain. If the StateFile is set, the outcome of each stage is saved to it,
+and the stages a previous run completed without failures for the same settings
+are skipped, unless an earlier stage has created resources since.
+
+The zones, offerings and template used are resolved and checked by Discover
+before anything is created. The networks and VPCs are spread across the zones,
+and the VMs and volumes are created in the zone of their network and VM, the
+VMs being deployed in the shared, isolated and VPC tier networks alike. The
+deployments of the first VM of the isolated networks, which also deploy their
+virtual router, are returned under the router type. In the distribute endpoint
+mode the operations are spread across the endpoints, otherwise they are all
+sent to the url.
+
+Once the context is done no further operation is started, the in-flight ones
+are waited for up to the ShutdownTimeout, and the results collected so far are
+returned along with the context error.
+*/
+func (b *Bench) Create(ctx context.Context, stages Stages, workers int) (Results, error) {
+	return b.create(ctx, stages, workers, nil)
+}
+
+/*
+PlanCreate runs the discovery done by Create, resolving the zones, offerings
+and template and listing the existing domains, accounts, networks, VMs and
+volumes, and returns the operations Create would make, without making any
+change. The stages are planned against the existing resources, so e.g. the
+networks of the domains the domain stage would create are not planned. The
+stages completed according to the StateFile are skipped, and neither the state
+nor the inventory file are written.
+*/
+func (b *Bench) PlanCreate(ctx context.Context, stages Stages) (*DryRunReport, error) {
+	report := &DryRunReport{}
+	_, err := b.create(ctx, stages, 1, report)
+	return report, err
+}
+
+// create runs Create, or plans it if report is not nil.
+func (b *Bench) create(ctx context.Context, stages Stages, workers int, report *DryRunReport) (Results, error) {
+	profile, err := b.adminProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	graceCtx, cancel := utils.WithGracePeriod(ctx, b.ShutdownTimeout)
+	defer cancel()
+
+	if err := b.Discover(ctx, stages); err != nil {
+		return nil, fmt.Errorf("error validating the configuration: %w", err)
+	}
+
+	clients := newEndpointClients(graceCtx, createEndpoints(b.cfg), profile)
+	cs := clients.primary()
+	parentDomainId := b.cfg.ParentDomainId
+	dash := b.Dashboard
+
+	var inv *Inventory
+	if report == nil {
+		if inv, err = b.openInventory(); err != nil {
+			return nil, err
+		}
+		defer inv.Close()
+	}
+
+	var state *CreateState
+	if b.StateFile != "" {
+		if state, err = readState(b.StateFile, parentDomainId); err != nil {
+			return nil, err
+		}
+	}
+
+	steps := []struct {
+		enabled bool
+		name    string
+		// target describes the settings the stage converges to.
+		target string
+		run    func(newPool func() *workerPool) []*Result
+	}{
+		{stages.Domain, "domain", fmt.Sprintf("numdomains=%d domaindepth=%d domainfanout=%d", b.cfg.NumDomains, b.cfg.DomainDepth, b.cfg.DomainFanout), func(newPool func() *workerPool) []*Result {
+			return createDomains(newPool, cs, b.cfg, parentDomainId, inv, report, dash)
+		}},
+		{stages.Limits, "limits", "", func(newPool func() *workerPool) []*Result {
+			return updateLimits(newPool(), cs, b.cfg, parentDomainId, report, dash)
+		}},
+		{stages.Network, "network", "zoneid=" + strings.Join(b.cfg.ZoneIds, ","), func(newPool func() *workerPool) []*Result {
+			return createNetwork(newPool(), cs, b.cfg, parentDomainId, inv, report, dash)
+		}},
+		{stages.Vpc, "vpc", fmt.Sprintf("numvpcs=%d numtiers=%d vpccidr=%s zoneid=%s", b.cfg.NumVpcs, b.cfg.NumTiers, b.cfg.VpcCidr, strings.Join(b.cfg.ZoneIds, ",")), func(newPool func() *workerPool) []*Result {
+			return createVpcs(newPool, cs, b.cfg, parentDomainId, inv, report, dash)
+		}},
+		{stages.Isolated, "isolated", fmt.Sprintf("numisolated=%d numpublicips=%d zoneid=%s", b.cfg.NumIsolated, b.cfg.NumPublicIps, strings.Join(b.cfg.ZoneIds, ",")), func(newPool func() *workerPool) []*Result {
+			return createIsolated(newPool, cs, b.cfg, parentDomainId, inv, report, dash)
+		}},
+		{stages.Vm, "vm", fmt.Sprintf("numvms=%d", b.cfg.NumVms), func(newPool func() *workerPool) []*Result {
+			return createVms(newPool, cs, b.cfg, parentDomainId, b.cfg.NumVms, inv, report, dash)
+		}},
+		{stages.StaticNat, "staticnat", "", func(newPool func() *workerPool) []*Result {
+			return createStaticNat(newPool(), cs, b.cfg, parentDomainId, report, dash)
+		}},
+		{stages.Rules, "rules", fmt.Sprintf("numfirewallrules=%d numegressrules=%d numportforwardingrules=%d numlbrules=%d", b.cfg.NumFirewallRules, b.cfg.NumEgressRules, b.cfg.NumPfRules, b.cfg.NumLbRules), func(newPool func() *workerPool) []*Result {
+			return createRules(newPool, cs, b.cfg, parentDomainId, report, dash)
+		}},
+		{stages.Volume, "volume", fmt.Sprintf("numvolumes=%d", b.cfg.NumVolumes), func(newPool func() *workerPool) []*Result {
+			return createVolumes(newPool(), cs, b.cfg, parentDomainId, b.cfg.NumVolumes, inv, report, dash)
+		}},
+		{stages.Snapshot, "snapshot", fmt.Sprintf("numsnapshots=%d numvmsnapshots=%d snapshotpolicy=%s", b.cfg.NumSnapshots, b.cfg.NumVmSnapshots, b.cfg.SnapshotPolicy), func(newPool func() *workerPool) []*Result {
+			return createSnapshots(newPool, cs, b.cfg, parentDomainId, inv, report, dash)
+		}},
+		{stages.Images, "images", fmt.Sprintf("numtemplates=%d numisos=%d imagevisibility=%s templateurl=%s isourl=%s zoneid=%s", b.cfg.NumTemplates, b.cfg.NumIsos, strings.Join(b.cfg.ImageVisibility, ","), b.cfg.TemplateUrl, b.cfg.IsoUrl, strings.Join(b.cfg.ZoneIds, ",")), func(newPool func() *workerPool) []*Result {
+			return createImages(newPool(), cs, b.cfg, parentDomainId, inv, report, dash)
+		}},
+	}
+	newPool := func() *workerPool {
+		return newWorkerPool(ctx, graceCtx, workers, clients, dash)
+	}
+
+	results := make(Results)
+	for i, step := range steps {
+		if !step.enabled {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		if state != nil && state.completed(step.name, step.target) {
+			log.Infof("Skipping the %s stage, completed by a previous run as recorded in %s", step.name, b.StateFile)
+			continue
+		}
+		stageResults := step.run(newPool)
+		if len(stageResults) == 0 {
+			results[step.name] = nil
+		}
+		for _, result := range stageResults {
+			resultType := step.name
+			if result.Type != "" {
+				resultType = result.Type
+			}
+			results[resultType] = append(results[resultType], result)
+		}
+		if state == nil || report != nil {
+			continue
+		}
+		state.record(step.name, step.target, stageResults, ctx.Err() != nil)
+		if state.Stages[step.name].Succeeded > 0 {
+			for _, later := range steps[i+1:] {
+				state.reset(later.name)
+			}
+		}
+		if err := state.write(b.StateFile); err != nil {
+			return results, err
+		}
+	}
+	return results, ctx.Err()
+}
+
+/*
+listOwnedSubDomains lists the subdomains of the parent domain which csbench
+created, told apart by their name, so that the stages converge them and leave
+alone the domains others created under the parent domain, along with anything
+below them.
+*/
+func listOwnedSubDomains(cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string) ([]*cloudstack.DomainChildren, error) {
+	all, err := domain.ListAllSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[string]*cloudstack.DomainChildren)
+	for _, dmn := range all {
+		byId[dmn.Id] = dmn
+	}
+	owned := make(map[string]bool)
+	var isOwned func(dmn *cloudstack.DomainChildren) bool
+	isOwned = func(dmn *cloudstack.DomainChildren) bool {
+		if result, ok := owned[dmn.Id]; ok {
+			return result
+		}
+		result := cfg.IsResourceName(dmn.Name, config.KindDomain)
+		if result && dmn.Parentdomainid != parentDomainId {
+			parent, ok := byId[dmn.Parentdomainid]
+			result = ok && isOwned(parent)
+		}
+		owned[dmn.Id] = result
+		return result
+	}
+	var domains []*cloudstack.DomainChildren
+	for _, dmn := range all {
+		if isOwned(dmn) {
+			domains = append(domains, dmn)
+		}
+	}
+	if foreign := len(all) - len(domains); foreign > 0 {
+		log.Infof("Leaving out %d subdomains of domain %s not created by csbench", foreign, parentDomainId)
+	}
+	return domains, nil
+}
+
+// stageAborted logs that a stage stopped because listing the existing resources
+// failed, and returns the failure as its result so that the stage isn't
+// recorded as done.
+func stageAborted(stage string, api string, err error) []*Result {
+	log.Errorf("Aborting the %s stage, error listing the existing resources: %s", stage, err)
+	return []*Result{newResult(time.Now(), api, err)}
+}
+
+// domainSkipped logs that a stage skips a domain because listing its resources
+// failed, and returns the failure as a result so that the stage isn't recorded
+// as done and the domain is converged by the next run.
+func domainSkipped(domainId string, resources string, api string, err error) *Result {
+	log.Warnf("Skipping domain %s, error listing its %s: %s", domainId, resources, err)
+	return newResult(time.Now(), api, err)
+}
+
+// progressInterval returns every how many operations the progress of total
+// operations is logged.
+func progressInterval(total int) int {
+	if total < 10 {
+		return 1
+	}
+	return int(math.Ceil(float64(total) / 10.0))
+}
+
+/*
+createDomains converges the domain tree under the parent domain to numdomains
+domains, each with a domain admin account. With a domaindepth of more than 1,
+each domain of a level then gets domainfanout subdomains, level by level. Only
+the domains and domain admin accounts missing are created. If report is not
+nil the operations are only planned, with the domains to create numbered as
+they would be created in.
+*/
+func createDomains(newPool func() *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, inv *Inventory, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching subdomains & accounts for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("domain", "listDomainChildren", err)
+	}
+	children := make(map[string][]string)
+	for _, dmn := range domains {
+		children[dmn.Parentdomainid] = append(children[dmn.Parentdomainid], dmn.Id)
+	}
+	accounts, err := domain.ListAllAccounts(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("domain", "listAccounts", err)
+	}
+	hasAccount := make(map[string]bool)
+	for _, account := range accounts {
+		hasAccount[account.Domainid] = true
+	}
+
+	progressMarker := progressInterval(cfg.DomainTreeSize())
+	start := time.Now()
+
+	var results []*Result
+	var created atomic.Int64
+	planned := 0
+	parentIds := []string{parentDomainId}
+	count := cfg.NumDomains
+	for depth := 1; depth <= cfg.DomainDepth && len(parentIds) > 0; depth++ {
+		if depth > 1 {
+			count = cfg.DomainFanout
+		}
+		var existingIds, noAccountIds []string
+		missing := 0
+		for _, parentId := range parentIds {
+			existingIds = append(existingIds, children[parentId]...)
+			if n := len(children[parentId]); n < count {
+				missing += count - n
+			}
+		}
+		for _, id := range existingIds {
+			if !hasAccount[id] {
+				noAccountIds = append(noAccountIds, id)
+			}
+		}
+		log.Infof("Creating %d domains at depth %d, %d exist already", missing, depth, len(existingIds))
+		if len(noAccountIds) > 0 {
+			log.Infof("Creating the domain admin account of %d existing domains at depth %d", len(noAccountIds), depth)
+		}
+		dash.AddTotal(missing + len(noAccountIds))
+
+		var mu sync.Mutex
+		levelIds := append([]string{}, existingIds...)
+		if report != nil {
+			for _, id := range noAccountIds {
+				report.add("domain", "createAccount", id)
+			}
+			for _, parentId := range parentIds {
+				for i := len(children[parentId]); i < count; i++ {
+					planned++
+					id := fmt.Sprintf("(new domain %d)", planned)
+					report.add("domain", "createDomain", parentId)
+					report.add("domain", "createAccount", id)
+					levelIds = append(levelIds, id)
+				}
+			}
+			parentIds = levelIds
+			continue
+		}
+		workerPool := newPool()
+		submitted := true
+		for _, id := range noAccountIds {
+			id := id
+			submitted = workerPool.Go("domain", func(cs *cloudstack.CloudStackClient) *Result {
+				taskStart := time.Now()
+				account, err := domain.CreateAccount(cs, cfg, id, domain.AccountTypeDomainAdmin)
+				if err == nil {
+					inv.add(ResourceAccount, account.Id, account.Name, id)
+				}
+				return newResult(taskStart, "createAccount", err)
+			})
+			if !submitted {
+				break
+			}
+		}
+	parents:
+		for _, parentId := range parentIds {
+			if !submitted {
+				break
+			}
+			parentId := parentId
+			for i := len(children[parentId]); i < count; i++ {
+				submitted = workerPool.Go("domain", func(cs *cloudstack.CloudStackClient) *Result {
+					taskStart := time.Now()
+					dmn, err := domain.CreateDomain(cs, cfg, parentId)
+					if err != nil {
+						return newResult(taskStart, "createDomain", err)
+					}
+					inv.add(ResourceDomain, dmn.Id, dmn.Name, parentId)
+					mu.Lock()
+					levelIds = append(levelIds, dmn.Id)
+					mu.Unlock()
+					if n := created.Add(1); n%int64(progressMarker) == 0 {
+						log.Infof("Created %d domains", n)
+					}
+					account, err := domain.CreateAccount(cs, cfg, dmn.Id, domain.AccountTypeDomainAdmin)
+					if err != nil {
+						return newResult(taskStart, "createAccount", err)
+					}
+					inv.add(ResourceAccount, account.Id, account.Name, dmn.Id)
+
+					return newResult(taskStart, "", nil)
+				})
+				if !submitted {
+					break parents
+				}
+			}
+		}
+		results = append(results, workerPool.Wait()...)
+		parentIds = levelIds
+	}
+	if report == nil {
+		log.Infof("Created %d domains in %.2f seconds", created.Load(), time.Since(start).Seconds())
+	}
+	return results
+}
+
+func updateLimits(workerPool *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching subdomains for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("limits", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	accounts := make([]*cloudstack.Account, 0)
+	for _, dmn := range domains {
+		domainAccounts, err := domain.ListAccounts(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "accounts", "listAccounts", err))
+			continue
+		}
+		accounts = append(accounts, domainAccounts...)
+	}
+
+	if report != nil {
+		for _, account := range accounts {
+			report.add("limits", "updateResourceLimit", account.Id)
+		}
+		return skipped
+	}
+
+	progressMarker := progressInterval(len(accounts))
+	start := time.Now()
+	log.Infof("Updating limits for %d accounts", len(accounts))
+	dash.AddTotal(len(accounts))
+	for i, account := range accounts {
+		if (i+1)%progressMarker == 0 {
+			log.Infof("Updated limits for %d accounts", i+1)
+		}
+		account := account
+		submitted := workerPool.Go("limits", func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			_, err := domain.UpdateLimits(cs, account)
+			return newResult(taskStart, "updateResourceLimit", err)
+		})
+		if !submitted {
+			break
+		}
+	}
+	res := append(skipped, workerPool.Wait()...)
+	log.Infof("Updated limits for %d accounts in %.2f seconds", len(accounts), time.Since(start).Seconds())
+	return res
+}
+
+// ownNetworks returns the networks which belong to the domain itself, leaving
+// out the ones of its parent domains it has access to.
+func ownNetworks(networks []*cloudstack.Network, domainId string) []*cloudstack.Network {
+	var own []*cloudstack.Network
+	for _, n := range networks {
+		if n.Domainid == domainId {
+			own = append(own, n)
+		}
+	}
+	return own
+}
+
+// hasSharedNetwork returns whether one of the networks is a shared network.
+func hasSharedNetwork(networks []*cloudstack.Network) bool {
+	for _, n := range networks {
+		if n.Type == "Shared" {
+			return true
+		}
+	}
+	return false
+}
+
+// vpcTierGateways returns the gateways of the tiers among the networks, by VPC
+// ID. A VPC has as many tiers as gateways.
+func vpcTierGateways(networks []*cloudstack.Network) map[string]map[string]bool {
+	gateways := make(map[string]map[string]bool)
+	for _, n := range networks {
+		if n.Vpcid == "" {
+			continue
+		}
+		if gateways[n.Vpcid] == nil {
+			gateways[n.Vpcid] = make(map[string]bool)
+		}
+		gateways[n.Vpcid][n.Gateway] = true
+	}
+	return gateways
+}
+
+// isolatedNetworks returns the isolated networks which are not VPC tiers.
+func isolatedNetworks(networks []*cloudstack.Network) []*cloudstack.Network {
+	var isolated []*cloudstack.Network
+	for _, n := range networks {
+		if n.Type == "Isolated" && n.Vpcid == "" {
+			isolated = append(isolated, n)
+		}
+	}
+	return isolated
+}
+
+// zoneAllocators returns the allocators of the VLANs and subnets of the shared
+// networks of each zone, which leave out the ones of the existing networks.
+func zoneAllocators(cs *cloudstack.CloudStackClient, cfg *config.Config) (map[string]*network.Allocator, error) {
+	allocators := make(map[string]*network.Allocator)
+	for _, zoneId := range cfg.ZoneIds {
+		networks, err := network.ListZoneNetworks(cs, cfg, zoneId)
+		if err != nil {
+			return nil, fmt.Errorf("error listing the networks of zone %s to check the VLANs in use: %w", zoneId, err)
+		}
+		allocators[zoneId] = network.NewAllocator(cfg, networks)
+	}
+	return allocators, nil
+}
+
+// createNetwork creates a shared network in each of the subdomains of the
+// parent domain which has none yet, with a VLAN and subnet which are not used
+// yet in its zone.
+func createNetwork(workerPool *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, inv *Inventory, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching subdomains & networks for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("network", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	var missing []int
+	for i, dmn := range domains {
+		networks, err := network.ListNetworks(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "networks", "listNetworks", err))
+			continue
+		}
+		if !hasSharedNetwork(ownNetworks(networks, dmn.Id)) {
+			missing = append(missing, i)
+		}
+	}
+
+	log.Infof("Creating %d networks, %d domains have one already", len(missing), len(domains)-len(missing))
+	if len(missing) == 0 {
+		return skipped
+	}
+	allocators, err := zoneAllocators(cs, cfg)
+	if err != nil {
+		log.Errorf("Not creating any network: %s", err)
+		return append(skipped, newResult(time.Now(), "listNetworks", err))
+	}
+	subnets := make(map[int]*network.Subnet)
+	for _, i := range missing {
+		zoneId := cfg.ZoneIds[i%len(cfg.ZoneIds)]
+		subnet, err := allocators[zoneId].Next()
+		if err != nil {
+			log.Warnf("Skipping the network of domain %s in zone %s: %s", domains[i].Id, zoneId, err)
+			continue
+		}
+		log.Debugf("Allocated VLAN %s and gateway %s/%s to the network of domain %s", subnet.Vlan, subnet.Gateway, subnet.Netmask, domains[i].Id)
+		subnets[i] = subnet
+	}
+	if report != nil {
+		for _, i := range missing {
+			if subnets[i] != nil {
+				report.add("network", "createNetwork", domains[i].Id)
+				report.add("network", "createTags", domains[i].Id)
+			}
+		}
+		return skipped
+	}
+
+	progressMarker := progressInterval(len(subnets))
+	start := time.Now()
+	dash.AddTotal(len(subnets))
+	count := 0
+	for _, i := range missing {
+		if subnets[i] == nil {
+			continue
+		}
+		count++
+		if count%progressMarker == 0 {
+			log.Infof("Created %d networks", count)
+		}
+		i := i
+		dmn := domains[i]
+		submitted := workerPool.Go("network", func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			resp, err := network.CreateNetwork(cs, cfg, cfg.ZoneIds[i%len(cfg.ZoneIds)], dmn.Id, "", subnets[i])
+			if err != nil {
+				return newResult(taskStart, "createNetwork", err)
+			}
+			inv.add(ResourceNetwork, resp.Id, resp.Name, dmn.Id)
+			if err := tags.CreateTags(cs, cfg, tags.ResourceTypeNetwork, resp.Id); err != nil {
+				return newResult(taskStart, "createTags", err)
+			}
+			return newResult(taskStart, "", nil)
+		})
+		if !submitted {
+			break
+		}
+	}
+	res := append(skipped, workerPool.Wait()...)
+	log.Infof("Created %d networks in %.2f seconds", len(subnets), time.Since(start).Seconds())
+	return res
+}
+
+// ownedResource is a resource to create in a domain, owned by its domain admin
+// account, by createVpcs or createIsolated.
+type ownedResource struct {
+	domainId string
+	account  string
+	zoneId   string
+}
+
+/*
+createVpcs converges each subdomain of the parent domain to numvpcs VPCs owned
+by its domain admin account, spread across the zones, each with numtiers tiers.
+The VPCs missing are created first along with a network ACL list allowing SSH
+and ping in and everything out, which is also created for the existing VPCs
+which have none and miss tiers. The missing tiers of all the VPCs are then
+created with that list, each in its own /24 of the vpccidr. If report is not
+nil the operations are only planned.
+*/
+func createVpcs(newPool func() *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, inv *Inventory, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching subdomains, accounts, VPCs & tiers for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("vpc", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	var vpcs, missingAcl []*cloudstack.VPC
+	var missing []ownedResource
+	aclIds := make(map[string]string)
+	usedGateways := make(map[string]map[string]bool)
+	for i, dmn := range domains {
+		accounts, err := domain.ListAccounts(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "accounts", "listAccounts", err))
+			continue
+		}
+		if len(accounts) == 0 {
+			log.Warnf("Skipping domain %s, it has no account to own the VPCs", dmn.Id)
+			continue
+		}
+		existing, err := vpc.ListVpcs(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "VPCs", "listVPCs", err))
+			continue
+		}
+		networks, err := network.ListNetworks(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "networks", "listNetworks", err))
+			continue
+		}
+		for vpcId, gateways := range vpcTierGateways(networks) {
+			usedGateways[vpcId] = gateways
+		}
+		own := 0
+		for _, v := range existing {
+			if v.Domainid != dmn.Id {
+				continue
+			}
+			own++
+			vpcs = append(vpcs, v)
+			if len(usedGateways[v.Id]) >= cfg.NumTiers {
+				continue
+			}
+			lists, err := vpc.ListAclLists(cs, v.Id)
+			if err != nil {
+				log.Warnf("Skipping VPC %s, error listing its network ACL lists: %s", v.Id, err)
+				continue
+			}
+			if len(lists) == 0 {
+				missingAcl = append(missingAcl, v)
+			} else {
+				aclIds[v.Id] = lists[0].Id
+			}
+		}
+		for j := own; j < cfg.NumVpcs; j++ {
+			missing = append(missing, ownedResource{dmn.Id, accounts[0].Name, cfg.ZoneIds[(i+j)%len(cfg.ZoneIds)]})
+		}
+	}
+
+	log.Infof("Creating %d VPCs, %d exist already", len(missing), len(vpcs))
+	if report != nil {
+		for _, v := range missingAcl {
+			report.add("vpc", "createNetworkACLList", v.Id)
+			for j := 0; j < vpc.NumAclRules(); j++ {
+				report.add("vpc", "createNetworkACL", v.Id)
+			}
+		}
+		for n, v := range missing {
+			id := fmt.Sprintf("(new vpc %d)", n+1)
+			report.add("vpc", "createVPC", v.domainId)
+			report.add("vpc", "createTags", id)
+			report.add("vpc", "createNetworkACLList", id)
+			for j := 0; j < vpc.NumAclRules(); j++ {
+				report.add("vpc", "createNetworkACL", id)
+			}
+			for j := 0; j < cfg.NumTiers; j++ {
+				report.add("vpc", "createNetwork", id)
+				report.add("vpc", "createTags", id)
+			}
+		}
+		for _, v := range vpcs {
+			for j := len(usedGateways[v.Id]); j < cfg.NumTiers; j++ {
+				report.add("vpc", "createNetwork", v.Id)
+				report.add("vpc", "createTags", v.Id)
+			}
+		}
+		return skipped
+	}
+
+	start := time.Now()
+	dash.AddTotal(len(missing) + len(missingAcl))
+	var mu sync.Mutex
+	createAcl := func(cs *cloudstack.CloudStackClient, vpcId string, taskStart time.Time) *Result {
+		aclId, err := vpc.CreateAclList(cs, cfg, vpcId)
+		if err != nil {
+			return newResult(taskStart, "createNetworkACLList", err)
+		}
+		if err := vpc.CreateAclRules(cs, aclId); err != nil {
+			return newResult(taskStart, "createNetworkACL", err)
+		}
+		mu.Lock()
+		aclIds[vpcId] = aclId
+		mu.Unlock()
+		return newResult(taskStart, "", nil)
+	}
+
+	workerPool := newPool()
+	submitted := true
+	for _, v := range missingAcl {
+		v := v
+		submitted = workerPool.Go("vpc", func(cs *cloudstack.CloudStackClient) *Result {
+			return createAcl(cs, v.Id, time.Now())
+		})
+		if !submitted {
+			break
+		}
+	}
+	for _, v := range missing {
+		if !submitted {
+			break
+		}
+		v := v
+		submitted = workerPool.Go("vpc", func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			resp, err := vpc.CreateVpc(cs, cfg, v.zoneId, v.domainId, v.account)
+			if err != nil {
+				return newResult(taskStart, "createVPC", err)
+			}
+			inv.add(ResourceVpc, resp.Id, resp.Name, v.domainId)
+			mu.Lock()
+			vpcs = append(vpcs, &cloudstack.VPC{Id: resp.Id, Name: resp.Name, Account: v.account, Domainid: v.domainId, Zoneid: v.zoneId, Cidr: cfg.VpcCidr})
+			mu.Unlock()
+			if err := tags.CreateTags(cs, cfg, tags.ResourceTypeVpc, resp.Id); err != nil {
+				return newResult(taskStart, "createTags", err)
+			}
+			return createAcl(cs, resp.Id, taskStart)
+		})
+	}
+	results := append(skipped, workerPool.Wait()...)
+	log.Infof("Created %d VPCs in %.2f seconds", len(missing), time.Since(start).Seconds())
+	if !submitted {
+		return results
+	}
+
+	type newTier struct {
+		vpc   *cloudstack.VPC
+		index int
+	}
+	var tiers []newTier
+	for _, v := range vpcs {
+		if aclIds[v.Id] == "" {
+			continue
+		}
+		for index, count := 0, len(usedGateways[v.Id]); count < cfg.NumTiers; index++ {
+			gateway, _, err := vpc.TierSubnet(v.Cidr, index)
+			if err != nil {
+				log.Warnf("Skipping the missing tiers of VPC %s: %s", v.Id, err)
+				break
+			}
+			if !usedGateways[v.Id][gateway] {
+				tiers = append(tiers, newTier{v, index})
+				count++
+			}
+		}
+	}
+
+	log.Infof("Creating %d VPC tiers", len(tiers))
+	progressMarker := progressInterval(len(tiers))
+	start = time.Now()
+	dash.AddTotal(len(tiers))
+	workerPool = newPool()
+	for n, tier := range tiers {
+		if (n+1)%progressMarker == 0 {
+			log.Infof("Created %d VPC tiers", n+1)
+		}
+		tier := tier
+		submitted := workerPool.Go("vpc", func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			resp, err := vpc.CreateTier(cs, cfg, tier.vpc, aclIds[tier.vpc.Id], tier.index)
+			if err != nil {
+				return newResult(taskStart, "createNetwork", err)
+			}
+			inv.add(ResourceNetwork, resp.Id, resp.Name, tier.vpc.Domainid)
+			if err := tags.CreateTags(cs, cfg, tags.ResourceTypeNetwork, resp.Id); err != nil {
+				return newResult(taskStart, "createTags", err)
+			}
+			return newResult(taskStart, "", nil)
+		})
+		if !submitted {
+			break
+		}
+	}
+	results = append(results, workerPool.Wait()...)
+	log.Infof("Created %d VPC tiers in %.2f seconds", len(tiers), time.Since(start).Seconds())
+	return results
+}
+
+/*
+createIsolated converges each subdomain of the parent domain to numisolated
+isolated networks owned by its domain admin account, spread across the zones,
+each with numpublicips public IP addresses acquired besides its source NAT
+one. The missing networks are created first, then the missing public IPs of
+all the isolated networks. If report is not nil the operations are only
+planned.
+*/
+func createIsolated(newPool func() *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, inv *Inventory, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching subdomains, accounts, isolated networks & public IPs for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("isolated", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	var networks []*cloudstack.Network
+	var missing []ownedResource
+	ipCount := make(map[string]int)
+	for i, dmn := range domains {
+		accounts, err := domain.ListAccounts(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "accounts", "listAccounts", err))
+			continue
+		}
+		if len(accounts) == 0 {
+			log.Warnf("Skipping domain %s, it has no account to own the isolated networks", dmn.Id)
+			continue
+		}
+		domainNetworks, err := network.ListNetworks(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "networks", "listNetworks", err))
+			continue
+		}
+		ips, err := address.ListPublicIps(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "public IPs", "listPublicIpAddresses", err))
+			continue
+		}
+		for _, ip := range ips {
+			if !ip.Issourcenat && ip.Associatednetworkid != "" {
+				ipCount[ip.Associatednetworkid]++
+			}
+		}
+		isolated := isolatedNetworks(ownNetworks(domainNetworks, dmn.Id))
+		networks = append(networks, isolated...)
+		for j := len(isolated); j < cfg.NumIsolated; j++ {
+			missing = append(missing, ownedResource{dmn.Id, accounts[0].Name, cfg.ZoneIds[(i+j)%len(cfg.ZoneIds)]})
+		}
+	}
+
+	log.Infof("Creating %d isolated networks, %d exist already", len(missing), len(networks))
+	if report != nil {
+		for n, isolated := range missing {
+			id := fmt.Sprintf("(new isolated network %d)", n+1)
+			report.add("isolated", "createNetwork", isolated.domainId)
+			report.add("isolated", "createTags", id)
+			for j := 0; j < cfg.NumPublicIps; j++ {
+				report.add("isolated", "associateIpAddress", id)
+				report.add("isolated", "createTags", id)
+			}
+		}
+		for _, n := range networks {
+			for j := ipCount[n.Id]; j < cfg.NumPublicIps; j++ {
+				report.add("isolated", "associateIpAddress", n.Id)
+				report.add("isolated", "createTags", n.Id)
+			}
+		}
+		return skipped
+	}
+
+	progressMarker := progressInterval(len(missing))
+	start := time.Now()
+	dash.AddTotal(len(missing))
+	var mu sync.Mutex
+	workerPool := newPool()
+	submitted := true
+	for n, isolated := range missing {
+		if (n+1)%progressMarker == 0 {
+			log.Infof("Created %d isolated networks", n+1)
+		}
+		isolated := isolated
+		submitted = workerPool.Go("isolated", func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			resp, err := network.CreateIsolatedNetwork(cs, cfg, isolated.zoneId, isolated.domainId, isolated.account)
+			if err != nil {
+				return newResult(taskStart, "createNetwork", err)
+			}
+			inv.add(ResourceNetwork, resp.Id, resp.Name, isolated.domainId)
+			mu.Lock()
+			networks = append(networks, &cloudstack.Network{Id: resp.Id, Name: resp.Name, Account: isolated.account, Domainid: isolated.domainId, Zoneid: isolated.zoneId})
+			mu.Unlock()
+			if err := tags.CreateTags(cs, cfg, tags.ResourceTypeNetwork, resp.Id); err != nil {
+				return newResult(taskStart, "createTags", err)
+			}
+			return newResult(taskStart, "", nil)
+		})
+		if !submitted {
+			break
+		}
+	}
+	results := append(skipped, workerPool.Wait()...)
+	log.Infof("Created %d isolated networks in %.2f seconds", len(missing), time.Since(start).Seconds())
+	if !submitted {
+		return results
+	}
+
+	var ipNetworks []*cloudstack.Network
+	for _, n := range networks {
+		for j := ipCount[n.Id]; j < cfg.NumPublicIps; j++ {
+			ipNetworks = append(ipNetworks, n)
+		}
+	}
+	log.Infof("Acquiring %d public IPs", len(ipNetworks))
+	progressMarker = progressInterval(len(ipNetworks))
+	start = time.Now()
+	dash.AddTotal(len(ipNetworks))
+	workerPool = newPool()
+	for n, isolated := range ipNetworks {
+		if (n+1)%progressMarker == 0 {
+			log.Infof("Acquired %d public IPs", n+1)
+		}
+		isolated := isolated
+		submitted := workerPool.Go("isolated", func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			ip, err := address.AssociateIp(cs, isolated.Id)
+			if err != nil {
+				return newResult(taskStart, "associateIpAddress", err)
+			}
+			inv.add(ResourcePublicIp, ip.Id, ip.Ipaddress, isolated.Domainid)
+			if err := tags.CreateTags(cs, cfg, tags.ResourceTypePublicIp, ip.Id); err != nil {
+				return newResult(taskStart, "createTags", err)
+			}
+			return newResult(taskStart, "", nil)
+		})
+		if !submitted {
+			break
+		}
+	}
+	results = append(results, workerPool.Wait()...)
+	log.Infof("Acquired %d public IPs in %.2f seconds", len(ipNetworks), time.Since(start).Seconds())
+	return results
+}
+
+/*
+createStaticNat enables static NAT from the public IPs acquired for the
+isolated networks of the subdomains of the parent domain to the running or
+stopped VMs of these networks, one IP per VM, until either every IP or every VM
+of a network has static NAT.
+*/
+func createStaticNat(workerPool *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching isolated networks, public IPs & VMs for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("staticnat", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	type staticNat struct {
+		ipId string
+		vmId string
+	}
+	var pairs []staticNat
+	existing := 0
+	for _, dmn := range domains {
+		networks, err := network.ListNetworks(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "networks", "listNetworks", err))
+			continue
+		}
+		ips, err := address.ListPublicIps(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "public IPs", "listPublicIpAddresses", err))
+			continue
+		}
+		vms, err := vm.ListVMs(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "VMs", "listVirtualMachines", err))
+			continue
+		}
+		natted := make(map[string]bool)
+		for _, ip := range ips {
+			if ip.Isstaticnat {
+				natted[ip.Virtualmachineid] = true
+				existing++
+			}
+		}
+		for _, n := range isolatedNetworks(ownNetworks(networks, dmn.Id)) {
+			var freeIps, freeVms []string
+			for _, ip := range ips {
+				if ip.Associatednetworkid == n.Id && !ip.Issourcenat && !ip.Isstaticnat {
+					freeIps = append(freeIps, ip.Id)
+				}
+			}
+			for _, v := range vms {
+				if natted[v.Id] || v.State != "Running" && v.State != "Stopped" {
+					continue
+				}
+				for _, nic := range v.Nic {
+					if nic.Networkid == n.Id {
+						freeVms = append(freeVms, v.Id)
+						break
+					}
+				}
+			}
+			for j := 0; j < len(freeIps) && j < len(freeVms); j++ {
+				pairs = append(pairs, staticNat{freeIps[j], freeVms[j]})
+			}
+		}
+	}
+
+	log.Infof("Enabling static NAT for %d public IPs, %d have it already", len(pairs), existing)
+	if report != nil {
+		for _, pair := range pairs {
+			report.add("staticnat", "enableStaticNat", pair.ipId)
+		}
+		return skipped
+	}
+
+	progressMarker := progressInterval(len(pairs))
+	start := time.Now()
+	dash.AddTotal(len(pairs))
+	for n, pair := range pairs {
+		if (n+1)%progressMarker == 0 {
+			log.Infof("Enabled static NAT for %d public IPs", n+1)
+		}
+		pair := pair
+		submitted := workerPool.Go("staticnat", func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			_, err := address.EnableStaticNat(cs, pair.ipId, pair.vmId)
+			return newResult(taskStart, "enableStaticNat", err)
+		})
+		if !submitted {
+			break
+		}
+	}
+	res := append(skipped, workerPool.Wait()...)
+	log.Infof("Enabled static NAT for %d public IPs in %.2f seconds", len(pairs), time.Since(start).Seconds())
+	return res
+}
+
+// rulePortStart is the first port of the rules created, the lower ones being
+// left to the services of the VMs.
+const rulePortStart = 1024
+
+// nextFreePort marks the first port from rulePortStart which is not used yet
+// as used and returns it, or returns false if every port is used.
+func nextFreePort(used map[int]bool) (int, bool) {
+	for port := rulePortStart; port <= 65535; port++ {
+		if !used[port] {
+			used[port] = true
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// markPorts marks the ports from start to end, as returned by the rule APIs,
+// as used.
+func markPorts(used map[int]bool, start string, end string) {
+	first, err := strconv.Atoi(start)
+	if err != nil {
+		return
+	}
+	last, err := strconv.Atoi(end)
+	if err != nil {
+		last = first
+	}
+	for port := first; port <= last; port++ {
+		used[port] = true
+	}
+}
+
+/*
+createRules converges the isolated networks of the subdomains of the parent
+domain to numegressrules egress firewall rules each, and their public IPs to
+numfirewallrules firewall rules each and, unless they have static NAT,
+numportforwardingrules port forwarding rules to the VMs of their network and
+numlbrules load balancer rules. Every rule is for a single TCP port which no
+other rule of its IP, or egress rule of its network, uses. Once the rules are
+created, the running or stopped VMs of each network are assigned to the load
+balancer rules of its IPs they are not assigned to yet. The results are
+reported per kind of rule, and for the assignments under lbassign.
+*/
+func createRules(newPool func() *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching isolated networks, public IPs, VMs & rules for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("rules", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	type rule struct {
+		kind      string
+		api       string
+		networkId string
+		ipId      string
+		port      int
+		// vmIds are the VMs to forward the port to, the first one, or to
+		// assign to the load balancer rule.
+		vmIds []string
+	}
+	type assignment struct {
+		ruleId string
+		vmIds  []string
+	}
+	var missing []rule
+	var assignments []assignment
+	existing := 0
+	noVms := 0
+	for _, dmn := range domains {
+		networks, err := network.ListNetworks(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "networks", "listNetworks", err))
+			continue
+		}
+		isolated := isolatedNetworks(ownNetworks(networks, dmn.Id))
+		if len(isolated) == 0 {
+			continue
+		}
+		ips, err := address.ListPublicIps(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "public IPs", "listPublicIpAddresses", err))
+			continue
+		}
+		vms, err := vm.ListVMs(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "VMs", "listVirtualMachines", err))
+			continue
+		}
+		firewallRules, err := rules.ListFirewallRules(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "firewall rules", "listFirewallRules", err))
+			continue
+		}
+		egressRules, err := rules.ListEgressRules(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "egress rules", "listEgressFirewallRules", err))
+			continue
+		}
+		pfRules, err := rules.ListPortForwardingRules(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "port forwarding rules", "listPortForwardingRules", err))
+			continue
+		}
+		lbRules, err := rules.ListLoadBalancerRules(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "load balancer rules", "listLoadBalancerRules", err))
+			continue
+		}
+
+		networkVms := make(map[string][]string)
+		for _, v := range vms {
+			if v.State != "Running" && v.State != "Stopped" {
+				continue
+			}
+			for _, nic := range v.Nic {
+				networkVms[nic.Networkid] = append(networkVms[nic.Networkid], v.Id)
+			}
+		}
+		usedPorts := make(map[string]map[int]bool)
+		egressPorts := make(map[string]map[int]bool)
+		for _, n := range isolated {
+			egressPorts[n.Id] = make(map[int]bool)
+		}
+		for _, ip := range ips {
+			usedPorts[ip.Id] = make(map[int]bool)
+		}
+		ruleCount := make(map[string]int)
+		for _, r := range firewallRules {
+			if used, ok := usedPorts[r.Ipaddressid]; ok {
+				markPorts(used, strconv.Itoa(r.Startport), strconv.Itoa(r.Endport))
+				ruleCount["firewall/"+r.Ipaddressid]++
+			}
+		}
+		for _, r := range egressRules {
+			if used, ok := egressPorts[r.Networkid]; ok {
+				markPorts(used, strconv.Itoa(r.Startport), strconv.Itoa(r.Endport))
+				ruleCount["egress/"+r.Networkid]++
+			}
+		}
+		for _, r := range pfRules {
+			if used, ok := usedPorts[r.Ipaddressid]; ok {
+				markPorts(used, r.Publicport, r.Publicendport)
+				ruleCount["portforwarding/"+r.Ipaddressid]++
+			}
+		}
+		for _, r := range lbRules {
+			used, ok := usedPorts[r.Publicipid]
+			if !ok {
+				continue
+			}
+			markPorts(used, r.Publicport, r.Publicport)
+			ruleCount["lb/"+r.Publicipid]++
+			if len(networkVms[r.Networkid]) == 0 {
+				continue
+			}
+			assigned, err := rules.ListLoadBalancerVms(cs, cfg, r.Id)
+			if err != nil {
+				log.Warnf("Skipping load balancer rule %s, error listing its VMs: %s", r.Id, err)
+				continue
+			}
+			if vmIds := missingIds(networkVms[r.Networkid], assigned); len(vmIds) > 0 {
+				assignments = append(assignments, assignment{r.Id, vmIds})
+			}
+		}
+		for _, r := range ruleCount {
+			existing += r
+		}
+
+		// add adds the rules missing for the IP, or for the network if ipId is
+		// empty, with ports from used.
+		add := func(kind string, api string, networkId string, ipId string, count int, used map[int]bool) {
+			target := ipId
+			if target == "" {
+				target = networkId
+			}
+			for j := ruleCount[kind+"/"+target]; j < count; j++ {
+				port, ok := nextFreePort(used)
+				if !ok {
+					log.Warnf("Skipping the %s rules of %s, no free port left", kind, target)
+					return
+				}
+				r := rule{kind: kind, api: api, networkId: networkId, ipId: ipId, port: port}
+				switch vmIds := networkVms[networkId]; kind {
+				case "portforwarding":
+					if len(vmIds) == 0 {
+						noVms += count - j
+						return
+					}
+					r.vmIds = []string{vmIds[j%len(vmIds)]}
+				case "lb":
+					r.vmIds = vmIds
+				}
+				missing = append(missing, r)
+			}
+		}
+		for _, n := range isolated {
+			add("egress", "createEgressFirewallRule", n.Id, "", cfg.NumEgressRules, egressPorts[n.Id])
+			for _, ip := range ips {
+				if ip.Associatednetworkid != n.Id {
+					continue
+				}
+				add("firewall", "createFirewallRule", "", ip.Id, cfg.NumFirewallRules, usedPorts[ip.Id])
+				if ip.Isstaticnat {
+					continue
+				}
+				add("portforwarding", "createPortForwardingRule", n.Id, ip.Id, cfg.NumPfRules, usedPorts[ip.Id])
+				add("lb", "createLoadBalancerRule", n.Id, ip.Id, cfg.NumLbRules, usedPorts[ip.Id])
+			}
+		}
+	}
+	if noVms > 0 {
+		log.Warnf("Skipping %d port forwarding rules, their network has no running or stopped VM", noVms)
+	}
+
+	log.Infof("Creating %d rules, %d exist already", len(missing), existing)
+	if report != nil {
+		for n, r := range missing {
+			target := r.ipId
+			if target == "" {
+				target = r.networkId
+			}
+			report.add("rules", r.api, target)
+			report.add("rules", "createTags", fmt.Sprintf("(new %s rule %d)", r.kind, n+1))
+			if r.kind == "lb" && len(r.vmIds) > 0 {
+				report.add("rules", "assignToLoadBalancerRule", fmt.Sprintf("(new %s rule %d)", r.kind, n+1))
+			}
+		}
+		for _, a := range assignments {
+			report.add("rules", "assignToLoadBalancerRule", a.ruleId)
+		}
+		return skipped
+	}
+
+	progressMarker := progressInterval(len(missing))
+	start := time.Now()
+	dash.AddTotal(len(missing))
+	var mu sync.Mutex
+	workerPool := newPool()
+	submitted := true
+	for n, r := range missing {
+		if (n+1)%progressMarker == 0 {
+			log.Infof("Created %d rules", n+1)
+		}
+		r := r
+		submitted = workerPool.Go(r.kind, func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			var id, resourceType string
+			var err error
+			switch r.kind {
+			case "firewall":
+				var resp *cloudstack.CreateFirewallRuleResponse
+				if resp, err = rules.CreateFirewallRule(cs, r.ipId, r.port); err == nil {
+					id, resourceType = resp.Id, tags.ResourceTypeFirewallRule
+				}
+			case "egress":
+				var resp *cloudstack.CreateEgressFirewallRuleResponse
+				if resp, err = rules.CreateEgressRule(cs, r.networkId, r.port); err == nil {
+					id, resourceType = resp.Id, tags.ResourceTypeFirewallRule
+				}
+			case "portforwarding":
+				var resp *cloudstack.CreatePortForwardingRuleResponse
+				if resp, err = rules.CreatePortForwardingRule(cs, r.ipId, r.port, r.vmIds[0]); err == nil {
+					id, resourceType = resp.Id, tags.ResourceTypePortForwardingRule
+				}
+			case "lb":
+				var resp *cloudstack.CreateLoadBalancerRuleResponse
+				if resp, err = rules.CreateLoadBalancerRule(cs, cfg, r.ipId, r.networkId, r.port); err == nil {
+					id, resourceType = resp.Id, tags.ResourceTypeLoadBalancer
+				}
+			}
+			if err != nil {
+				return typedResult(newResult(taskStart, r.api, err), r.kind)
+			}
+			if r.kind == "lb" && len(r.vmIds) > 0 {
+				mu.Lock()
+				assignments = append(assignments, assignment{id, r.vmIds})
+				mu.Unlock()
+			}
+			if err := tags.CreateTags(cs, cfg, resourceType, id); err != nil {
+				return typedResult(newResult(taskStart, "createTags", err), r.kind)
+			}
+			return typedResult(newResult(taskStart, "", nil), r.kind)
+		})
+		if !submitted {
+			break
+		}
+	}
+	results := append(skipped, workerPool.Wait()...)
+	log.Infof("Created %d rules in %.2f seconds", len(missing), time.Since(start).Seconds())
+	if !submitted {
+		return results
+	}
+
+	log.Infof("Assigning VMs to %d load balancer rules", len(assignments))
+	progressMarker = progressInterval(len(assignments))
+	start = time.Now()
+	dash.AddTotal(len(assignments))
+	workerPool = newPool()
+	for n, a := range assignments {
+		if (n+1)%progressMarker == 0 {
+			log.Infof("Assigned VMs to %d load balancer rules", n+1)
+		}
+		a := a
+		submitted := workerPool.Go("lbassign", func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			_, err := rules.AssignToLoadBalancerRule(cs, a.ruleId, a.vmIds)
+			return typedResult(newResult(taskStart, "assignToLoadBalancerRule", err), "lbassign")
+		})
+		if !submitted {
+			break
+		}
+	}
+	results = append(results, workerPool.Wait()...)
+	log.Infof("Assigned VMs to %d load balancer rules in %.2f seconds", len(assignments), time.Since(start).Seconds())
+	return results
+}
+
+// typedResult sets the type the result is reported under.
+func typedResult(result *Result, resultType string) *Result {
+	result.Type = resultType
+	return result
+}
+
+// missingIds returns the IDs which are not in present.
+func missingIds(ids []string, present []string) []string {
+	found := make(map[string]bool)
+	for _, id := range present {
+		found[id] = true
+	}
+	var missing []string
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// networkVmCounts returns the number of active VMs among the VMs in each
+// network, by network ID.
+func networkVmCounts(vms []*cloudstack.VirtualMachine) map[string]int {
+	counts := make(map[string]int)
+	for _, v := range vms {
+		if !activeVm(v.State) {
+			continue
+		}
+		for _, nic := range v.Nic {
+			counts[nic.Networkid]++
+		}
+	}
+	return counts
+}
+
+// volumeVm returns whether data volumes are attached to the VM in the given
+// state.
+func volumeVm(state string) bool {
+	return state == "Running" || state == "Stopped"
+}
+
+// vmVolumeCounts returns the number of the volumes attached to each VM, by VM
+// ID.
+func vmVolumeCounts(volumes []*cloudstack.Volume) map[string]int {
+	counts := make(map[string]int)
+	for _, vol := range volumes {
+		if vol.Virtualmachineid != "" {
+			counts[vol.Virtualmachineid]++
+		}
+	}
+	return counts
+}
+
+// activeVm returns whether the VM in the given state counts towards the VMs of its network.
+func activeVm(state string) bool {
+	switch state {
+	case "Error", "Destroyed", "Expunging":
+		return false
+	}
+	return true
+}
+
+// createVms deploys VMs in the networks of the subdomains of the parent domain
+// until each network has numVmPerNetwork VMs. The first VM of the isolated
+// networks which are not implemented yet is deployed first, its result being
+// reported as the deployment of the router of the network.
+func createVms(newPool func() *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, numVmPerNetwork int, inv *Inventory, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching subdomains & accounts for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("vm", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	var accounts []*cloudstack.Account
+	for i := 0; i < len(domains); i++ {
+		account, err := domain.ListAccounts(cs, cfg, domains[i].Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(domains[i].Id, "accounts", "listAccounts", err))
+			continue
+		}
+		accounts = append(accounts, account...)
+	}
+
+	domainIdAccountMapping := make(map[string]*cloudstack.Account)
+	for _, account := range accounts {
+		domainIdAccountMapping[account.Domainid] = account
+	}
+
+	log.Infof("Fetching networks & VMs for subdomains in domain %s", parentDomainId)
+	var allNetworks []*cloudstack.Network
+	vmCount := make(map[string]int)
+	for _, dmn := range domains {
+		if domainIdAccountMapping[dmn.Id] == nil {
+			log.Warnf("Skipping domain %s, it has no account to deploy the VMs for", dmn.Id)
+			continue
+		}
+		networks, err := network.ListNetworks(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "networks", "listNetworks", err))
+			continue
+		}
+		vms, err := vm.ListVMs(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "VMs", "listVirtualMachines", err))
+			continue
+		}
+		for networkId, count := range networkVmCounts(vms) {
+			vmCount[networkId] += count
+		}
+		allNetworks = append(allNetworks, ownNetworks(networks, dmn.Id)...)
+	}
+
+	total, existing := 0, 0
+	for _, network := range allNetworks {
+		if n := vmCount[network.Id]; n < numVmPerNetwork {
+			total += numVmPerNetwork - n
+			existing += n
+		} else {
+			existing += numVmPerNetwork
+		}
+	}
+
+	log.Infof("Creating %d VMs, %d exist already", total, existing)
+	if report != nil {
+		for _, network := range allNetworks {
+			for j := vmCount[network.Id]; j < numVmPerNetwork; j++ {
+				report.add("vm", "deployVirtualMachine", network.Id)
+				report.add("vm", "createTags", network.Id)
+			}
+		}
+		return skipped
+	}
+
+	// The first VM of an isolated network which is not implemented yet also
+	// deploys its virtual router. These are deployed first, on their own, and
+	// reported as routers.
+	var routerNetworks, deployments []*cloudstack.Network
+	for _, network := range allNetworks {
+		j := vmCount[network.Id]
+		if j < numVmPerNetwork && j == 0 && network.Type == "Isolated" && network.State == "Allocated" {
+			routerNetworks = append(routerNetworks, network)
+			j++
+		}
+		for ; j < numVmPerNetwork; j++ {
+			deployments = append(deployments, network)
+		}
+	}
+	if len(routerNetworks) > 0 {
+		log.Infof("Deploying the first VM of %d isolated networks along with their virtual router", len(routerNetworks))
+	}
+
+	progressMarker := progressInterval(total)
+	start := time.Now()
+	dash.AddTotal(total)
+	count := 0
+	submitted := true
+	res := skipped
+	for _, batch := range []struct {
+		networks   []*cloudstack.Network
+		resultType string
+	}{{routerNetworks, "router"}, {deployments, ""}} {
+		if len(batch.networks) == 0 || !submitted {
+			continue
+		}
+		workerPool := newPool()
+		for _, network := range batch.networks {
+			network := network
+			resultType := batch.resultType
+			count++
+			if count%progressMarker == 0 {
+				log.Infof("Created %d VMs", count)
+			}
+			submitted = workerPool.Go("vm", func(cs *cloudstack.CloudStackClient) *Result {
+				taskStart := time.Now()
+				// The VMs of an account network, e.g. a VPC tier, must belong to its account.
+				account := network.Account
+				if account == "" {
+					account = domainIdAccountMapping[network.Domainid].Name
+				}
+				resp, err := vm.DeployVm(cs, cfg, network.Zoneid, network.Domainid, network.Id, account)
+				if err != nil {
+					return typedResult(newResult(taskStart, "deployVirtualMachine", err), resultType)
+				}
+				inv.add(ResourceVm, resp.Id, resp.Name, network.Domainid)
+				if err := tags.CreateTags(cs, cfg, tags.ResourceTypeVm, resp.Id); err != nil {
+					return typedResult(newResult(taskStart, "createTags", err), resultType)
+				}
+				return typedResult(newResult(taskStart, "", nil), resultType)
+			})
+			if !submitted {
+				break
+			}
+		}
+		res = append(res, workerPool.Wait()...)
+	}
+	log.Infof("Created %d VMs in %.2f seconds", total, time.Since(start).Seconds())
+	return res
+}
+
+// createVolumes creates and attaches data volumes to the VMs of the subdomains
+// of the parent domain until each VM has numVolumesPerVM of them.
+func createVolumes(workerPool *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, numVolumesPerVM int, inv *Inventory, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching all VMs & volumes in subdomains for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("volume", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	var allVMs []*cloudstack.VirtualMachine
+	volumeCount := make(map[string]int)
+	for _, dmn := range domains {
+		vms, err := vm.ListVMs(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "VMs", "listVirtualMachines", err))
+			continue
+		}
+		volumes, err := volume.ListDataVolumes(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "volumes", "listVolumes", err))
+			continue
+		}
+		for vmId, count := range vmVolumeCounts(volumes) {
+			volumeCount[vmId] += count
+		}
+		allVMs = append(allVMs, vms...)
+	}
+
+	total, existing := 0, 0
+	unsuitableVmCount := 0
+	var suitableVMs []*cloudstack.VirtualMachine
+	for _, vm := range allVMs {
+		if !volumeVm(vm.State) {
+			unsuitableVmCount++
+			continue
+		}
+		suitableVMs = append(suitableVMs, vm)
+		if n := volumeCount[vm.Id]; n < numVolumesPerVM {
+			total += numVolumesPerVM - n
+			existing += n
+		} else {
+			existing += numVolumesPerVM
+		}
+	}
+
+	log.Infof("Creating %d volumes, %d exist already", total, existing)
+	if unsuitableVmCount > 0 {
+		log.Warnf("Found %d VMs in unsuitable state", unsuitableVmCount)
+	}
+	if report != nil {
+		for _, vm := range suitableVMs {
+			for j := volumeCount[vm.Id]; j < numVolumesPerVM; j++ {
+				report.add("volume", "createVolume", vm.Id)
+				report.add("volume", "createTags", vm.Id)
+				report.add("volume", "attachVolume", vm.Id)
+			}
+		}
+		return skipped
+	}
+
+	progressMarker := progressInterval(total)
+	start := time.Now()
+	count := 0
+
+vms:
+	for _, vm := range suitableVMs {
+		vm := vm
+		for j := volumeCount[vm.Id]; j < numVolumesPerVM; j++ {
+			count++
+			if count%progressMarker == 0 {
+				log.Infof("Created %d volumes", count)
+			}
+
+			dash.AddTotal(1)
+			submitted := workerPool.Go("volume", func(cs *cloudstack.CloudStackClient) *Result {
+				taskStart := time.Now()
+				vol, err := volume.CreateVolume(cs, cfg, vm.Zoneid, vm.Domainid, vm.Account)
+				if err != nil {
+					return newResult(taskStart, "createVolume", err)
+				}
+				inv.add(ResourceVolume, vol.Id, vol.Name, vm.Domainid)
+				if err := tags.CreateTags(cs, cfg, tags.ResourceTypeVolume, vol.Id); err != nil {
+					return newResult(taskStart, "createTags", err)
+				}
+				_, err = volume.AttachVolume(cs, vol.Id, vm.Id)
+				if err != nil {
+					return newResult(taskStart, "attachVolume", err)
+				}
+				return newResult(taskStart, "", nil)
+			})
+			if !submitted {
+				break vms
+			}
+		}
+	}
+	res := append(skipped, workerPool.Wait()...)
+	log.Infof("Created %d volumes in %.2f seconds", total, time.Since(start).Seconds())
+	return res
+}
+
+// activeSnapshot returns whether the volume or VM snapshot in the given state
+// counts towards the snapshots of its volume or VM.
+func activeSnapshot(state string) bool {
+	switch state {
+	case "Error", "Destroying", "Destroyed", "Expunging", "Removed":
+		return false
+	}
+	return true
+}
+
+/*
+createSnapshots converges the volumes of the running or stopped VMs of the
+subdomains of the parent domain to numsnapshots snapshots each, and these VMs
+to numvmsnapshots VM snapshots each. If snapshotpolicy is set, the volumes
+without a snapshot policy also get one of that interval type, keeping up to
+numsnapshots snapshots. CloudStack only takes one snapshot of a VM at a time,
+so the snapshots are taken in rounds, each taking at most one snapshot of every
+volume or VM, the volume snapshots first. The VM snapshots and the policies are
+reported under vmsnapshot and snapshotpolicy.
+*/
+func createSnapshots(newPool func() *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, inv *Inventory, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching VMs, volumes & snapshots for domain %s", parentDomainId)
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("snapshot", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	// target is a volume or VM missing snapshots.
+	type target struct {
+		id       string
+		domainId string
+		missing  int
+	}
+	var volumes, vms, policyVolumes []target
+	existing, existingVm := 0, 0
+	for _, dmn := range domains {
+		vmList, err := vm.ListVMs(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "VMs", "listVirtualMachines", err))
+			continue
+		}
+		volumeList, err := volume.ListVolumes(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "volumes", "listVolumes", err))
+			continue
+		}
+		snapshots, err := snapshot.ListSnapshots(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "snapshots", "listSnapshots", err))
+			continue
+		}
+		vmSnapshots, err := snapshot.ListVmSnapshots(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "VM snapshots", "listVMSnapshot", err))
+			continue
+		}
+
+		snapshotCount := make(map[string]int)
+		for _, s := range snapshots {
+			if activeSnapshot(s.State) {
+				snapshotCount[s.Volumeid]++
+				existing++
+			}
+		}
+		for _, s := range vmSnapshots {
+			if activeSnapshot(s.State) {
+				snapshotCount[s.Virtualmachineid]++
+				existingVm++
+			}
+		}
+		snapshottable := make(map[string]bool)
+		for _, v := range vmList {
+			if v.State != "Running" && v.State != "Stopped" {
+				continue
+			}
+			snapshottable[v.Id] = true
+			if missing := cfg.NumVmSnapshots - snapshotCount[v.Id]; missing > 0 {
+				vms = append(vms, target{v.Id, dmn.Id, missing})
+			}
+		}
+		for _, vol := range volumeList {
+			if vol.State != "Ready" || !snapshottable[vol.Virtualmachineid] {
+				continue
+			}
+			if missing := cfg.NumSnapshots - snapshotCount[vol.Id]; missing > 0 {
+				volumes = append(volumes, target{vol.Id, dmn.Id, missing})
+			}
+			if cfg.SnapshotPolicy == "" {
+				continue
+			}
+			policies, err := snapshot.ListSnapshotPolicies(cs, cfg, vol.Id)
+			if err != nil {
+				log.Warnf("Skipping the snapshot policy of volume %s, error listing its policies: %s", vol.Id, err)
+				continue
+			}
+			if len(policies) == 0 {
+				policyVolumes = append(policyVolumes, target{vol.Id, dmn.Id, 1})
+			}
+		}
+	}
+	total := func(targets []target) int {
+		count := 0
+		for _, t := range targets {
+			count += t.missing
+		}
+		return count
+	}
+
+	log.Infof("Taking %d snapshots and %d VM snapshots, %d and %d exist already", total(volumes), total(vms), existing, existingVm)
+	if cfg.SnapshotPolicy != "" {
+		log.Infof("Creating %d %s snapshot policies", len(policyVolumes), cfg.SnapshotPolicy)
+	}
+	if report != nil {
+		for _, t := range policyVolumes {
+			report.add("snapshot", "createSnapshotPolicy", t.id)
+		}
+		for _, t := range volumes {
+			for j := 0; j < t.missing; j++ {
+				report.add("snapshot", "createSnapshot", t.id)
+				report.add("snapshot", "createTags", t.id)
+			}
+		}
+		for _, t := range vms {
+			for j := 0; j < t.missing; j++ {
+				report.add("snapshot", "createVMSnapshot", t.id)
+				report.add("snapshot", "createTags", t.id)
+			}
+		}
+		return skipped
+	}
+
+	maxSnaps := cfg.NumSnapshots
+	if maxSnaps < 1 {
+		maxSnaps = 1
+	}
+	dash.AddTotal(len(policyVolumes) + total(volumes) + total(vms))
+	results := skipped
+	// runRounds runs take for every target missing a snapshot, round after
+	// round, and returns false if the context is done.
+	runRounds := func(command string, name string, targets []target, take func(cs *cloudstack.CloudStackClient, t target) *Result) bool {
+		if len(targets) == 0 {
+			return true
+		}
+		start := time.Now()
+		count := 0
+		for round := 0; ; round++ {
+			var batch []target
+			for _, t := range targets {
+				if t.missing > round {
+					batch = append(batch, t)
+				}
+			}
+			if len(batch) == 0 {
+				break
+			}
+			workerPool := newPool()
+			submitted := true
+			for _, t := range batch {
+				t := t
+				submitted = workerPool.Go(command, func(cs *cloudstack.CloudStackClient) *Result {
+					return take(cs, t)
+				})
+				if !submitted {
+					break
+				}
+			}
+			results = append(results, workerPool.Wait()...)
+			if !submitted {
+				return false
+			}
+			count += len(batch)
+			log.Infof("Created %d %s", count, name)
+		}
+		log.Infof("Created %d %s in %.2f seconds", count, name, time.Since(start).Seconds())
+		return true
+	}
+
+	ok := runRounds("snapshotpolicy", "snapshot policies", policyVolumes, func(cs *cloudstack.CloudStackClient, t target) *Result {
+		taskStart := time.Now()
+		_, err := snapshot.CreateSnapshotPolicy(cs, cfg, t.id, maxSnaps)
+		return typedResult(newResult(taskStart, "createSnapshotPolicy", err), "snapshotpolicy")
+	}) && runRounds("snapshot", "snapshots", volumes, func(cs *cloudstack.CloudStackClient, t target) *Result {
+		taskStart := time.Now()
+		resp, err := snapshot.CreateSnapshot(cs, cfg, t.id)
+		if err != nil {
+			return newResult(taskStart, "createSnapshot", err)
+		}
+		inv.add(ResourceSnapshot, resp.Id, resp.Name, t.domainId)
+		if err := tags.CreateTags(cs, cfg, tags.ResourceTypeSnapshot, resp.Id); err != nil {
+			return newResult(taskStart, "createTags", err)
+		}
+		return newResult(taskStart, "", nil)
+	})
+	if ok {
+		runRounds("vmsnapshot", "VM snapshots", vms, func(cs *cloudstack.CloudStackClient, t target) *Result {
+			taskStart := time.Now()
+			resp, err := snapshot.CreateVmSnapshot(cs, cfg, t.id)
+			if err != nil {
+				return typedResult(newResult(taskStart, "createVMSnapshot", err), "vmsnapshot")
+			}
+			inv.add(ResourceVmSnapshot, resp.Id, resp.Name, t.domainId)
+			if err := tags.CreateTags(cs, cfg, tags.ResourceTypeVmSnapshot, resp.Id); err != nil {
+				return typedResult(newResult(taskStart, "createTags", err), "vmsnapshot")
+			}
+			return typedResult(newResult(taskStart, "", nil), "vmsnapshot")
+		})
+	}
+	return results
+}
+
+/*
+createImages converges every account of the subdomains of the parent domain to
+numtemplates templates registered from the templateurl and numisos ISOs
+registered from the isourl, spread across the zones. The visibility of the
+images of an account cycles through imagevisibility, so that listing the
+templates with templatefilter=all goes through private, public and featured
+templates alike. The templates take the hypervisor, format and OS type of the
+template of the VMs, the ISOs its OS type. The registrations are reported under
+the template and iso types.
+*/
+func createImages(workerPool *workerPool, cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string, inv *Inventory, report *DryRunReport, dash *dashboard.Dashboard) []*Result {
+	log.Infof("Fetching subdomains, accounts, templates & ISOs for domain %s", parentDomainId)
+	// registration is a template or ISO missing from an account.
+	type registration struct {
+		ownedResource
+		iso        bool
+		visibility string
+	}
+	domains, err := listOwnedSubDomains(cs, cfg, parentDomainId)
+	if err != nil {
+		return stageAborted("images", "listDomainChildren", err)
+	}
+	var skipped []*Result
+	var missing []registration
+	existing, existingIsos := 0, 0
+	for i, dmn := range domains {
+		templates, err := image.ListTemplates(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "templates", "listTemplates", err))
+			continue
+		}
+		isos, err := image.ListIsos(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "ISOs", "listIsos", err))
+			continue
+		}
+		templateCount := make(map[string]int)
+		for _, t := range templates {
+			if t.Domainid == dmn.Id {
+				templateCount[t.Account]++
+			}
+		}
+		isoCount := make(map[string]int)
+		for _, iso := range isos {
+			if iso.Domainid == dmn.Id {
+				isoCount[iso.Account]++
+			}
+		}
+		accounts, err := domain.ListAccounts(cs, cfg, dmn.Id)
+		if err != nil {
+			skipped = append(skipped, domainSkipped(dmn.Id, "accounts", "listAccounts", err))
+			continue
+		}
+		for _, account := range accounts {
+			existing += templateCount[account.Name]
+			existingIsos += isoCount[account.Name]
+			for j := templateCount[account.Name]; j < cfg.NumTemplates; j++ {
+				missing = append(missing, registration{
+					ownedResource{dmn.Id, account.Name, cfg.ZoneIds[(i+j)%len(cfg.ZoneIds)]},
+					false, cfg.ImageVisibility[j%len(cfg.ImageVisibility)],
+				})
+			}
+			for j := isoCount[account.Name]; j < cfg.NumIsos; j++ {
+				missing = append(missing, registration{
+					ownedResource{dmn.Id, account.Name, cfg.ZoneIds[(i+j)%len(cfg.ZoneIds)]},
+					true, cfg.ImageVisibility[j%len(cfg.ImageVisibility)],
+				})
+			}
+		}
+	}
+
+	log.Infof("Registering %d templates & ISOs, %d templates and %d ISOs exist already", len(missing), existing, existingIsos)
+	if report != nil {
+		for _, r := range missing {
+			target := r.domainId + "/" + r.account
+			if r.iso {
+				report.add("images", "registerIso", target)
+			} else {
+				report.add("images", "registerTemplate", target)
+			}
+			report.add("images", "createTags", target)
+		}
+		return skipped
+	}
+	if len(missing) == 0 {
+		return skipped
+	}
+	base, err := image.GetTemplate(cs, cfg.TemplateId)
+	if err != nil {
+		log.Errorf("Not registering any template or ISO: %s", err)
+		return append(skipped, newResult(time.Now(), "listTemplates", err))
+	}
+
+	progressMarker := progressInterval(len(missing))
+	start := time.Now()
+	dash.AddTotal(len(missing))
+	for n, r := range missing {
+		if (n+1)%progressMarker == 0 {
+			log.Infof("Registered %d templates & ISOs", n+1)
+		}
+		r := r
+		submitted := workerPool.Go("images", func(cs *cloudstack.CloudStackClient) *Result {
+			taskStart := time.Now()
+			if r.iso {
+				resp, err := image.RegisterIso(cs, cfg, base, r.zoneId, r.domainId, r.account, r.visibility)
+				if err != nil {
+					return typedResult(newResult(taskStart, "registerIso", err), "iso")
+				}
+				inv.add(ResourceIso, resp.Id, resp.Name, r.domainId)
+				if err := tags.CreateTags(cs, cfg, tags.ResourceTypeIso, resp.Id); err != nil {
+					return typedResult(newResult(taskStart, "createTags", err), "iso")
+				}
+				return typedResult(newResult(taskStart, "", nil), "iso")
+			}
+			resp, err := image.RegisterTemplate(cs, cfg, base, r.zoneId, r.domainId, r.account, r.visibility)
+			if err != nil {
+				return typedResult(newResult(taskStart, "registerTemplate", err), "template")
+			}
+			inv.add(ResourceTemplate, resp.Id, resp.Name, r.domainId)
+			if err := tags.CreateTags(cs, cfg, tags.ResourceTypeTemplate, resp.Id); err != nil {
+				return typedResult(newResult(taskStart, "createTags", err), "template")
+			}
+			return typedResult(newResult(taskStart, "", nil), "template")
+		})
+		if !submitted {
+			break
+		}
+	}
+	results := append(skipped, workerPool.Wait()...)
+	log.Infof("Registered %d templates & ISOs in %.2f seconds", len(missing), time.Since(start).Seconds())
+	return results
+}
@@ -0,0 +1,443 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"csbench/utils"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Discover resolves the zones, offerings and template needed by the stages and
+sets their IDs in the configuration. The IDs which are set are checked to
+exist, the ones which are not set are looked up by the configured name, and
+otherwise a default is picked:
+  - zone: the first enabled zone
+  - networkoffering: the first enabled shared network offering with specified
+    VLAN and IP ranges, as used to create the networks
+  - serviceoffering: the smallest fixed size user service offering
+  - diskoffering: the smallest fixed size disk offering
+  - template: the first ready featured user template
+  - vpcoffering: the default VPC offering if enabled, otherwise the first
+    enabled one
+  - vpctieroffering: the first enabled isolated network offering for VPCs
+  - isolatedoffering: the first enabled isolated network offering with source
+    NAT which is not for VPCs, and does not need a VLAN to be specified
+
+The template must also be ready in every zone. The images stage also needs the
+template, as its templates and ISOs take after it, and the templateurl and
+isourl to register them from. All the errors are returned together, so that
+the configuration can be fixed in one go.
+*/
+func (b *Bench) Discover(ctx context.Context, stages Stages) error {
+	profile, err := b.adminProfile()
+	if err != nil {
+		return err
+	}
+	cs := utils.NewAsyncClient(ctx, b.cfg.URL, profile.ApiKey, profile.SecretKey)
+
+	var errs []error
+	if stages.Images {
+		if b.cfg.NumTemplates > 0 && b.cfg.TemplateUrl == "" {
+			errs = append(errs, fmt.Errorf("templateurl must be set to register templates"))
+		}
+		if b.cfg.NumIsos > 0 && b.cfg.IsoUrl == "" {
+			errs = append(errs, fmt.Errorf("isourl must be set to register ISOs"))
+		}
+	}
+	if stages.Network || stages.Vpc || stages.Isolated || stages.Vm || stages.Images {
+		zoneIds, err := discoverZones(cs, b.cfg.ZoneIds, b.cfg.Zones)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		b.cfg.ZoneIds = zoneIds
+	}
+
+	resources := []struct {
+		enabled bool
+		id      *string
+		name    string
+		find    func(cs *cloudstack.CloudStackClient, id string, name string) (string, error)
+	}{
+		{stages.Network, &b.cfg.NetworkOfferingId, b.cfg.NetworkOffering, findNetworkOffering},
+		{stages.Vpc, &b.cfg.VpcOfferingId, b.cfg.VpcOffering, findVpcOffering},
+		{stages.Vpc, &b.cfg.VpcTierOfferingId, b.cfg.VpcTierOffering, findVpcTierOffering},
+		{stages.Isolated, &b.cfg.IsolatedOfferingId, b.cfg.IsolatedOffering, findIsolatedOffering},
+		{stages.Vm, &b.cfg.ServiceOfferingId, b.cfg.ServiceOffering, findServiceOffering},
+		{stages.Volume, &b.cfg.DiskOfferingId, b.cfg.DiskOffering, findDiskOffering},
+		{stages.Vm || stages.Images, &b.cfg.TemplateId, b.cfg.Template, func(cs *cloudstack.CloudStackClient, id string, name string) (string, error) {
+			return findTemplate(cs, id, name, b.cfg.ZoneIds)
+		}},
+	}
+	for _, resource := range resources {
+		if !resource.enabled {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		id, err := resource.find(cs, *resource.id, resource.name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		*resource.id = id
+	}
+	return errors.Join(errs...)
+}
+
+func discoverZones(cs *cloudstack.CloudStackClient, ids []string, names []string) ([]string, error) {
+	if len(ids) == 0 && len(names) == 0 {
+		id, err := findZone(cs, "", "")
+		if err != nil {
+			return nil, err
+		}
+		return []string{id}, nil
+	}
+
+	var zoneIds []string
+	var errs []error
+	for _, id := range ids {
+		if _, err := findZone(cs, id, ""); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		zoneIds = append(zoneIds, id)
+	}
+	for _, name := range names {
+		id, err := findZone(cs, "", name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		zoneIds = append(zoneIds, id)
+	}
+	return zoneIds, errors.Join(errs...)
+}
+
+// describe returns how a resource was looked up, for the error messages.
+func describe(resource string, id string, name string) string {
+	switch {
+	case id != "":
+		return fmt.Sprintf("%s with id %s", resource, id)
+	case name != "":
+		return fmt.Sprintf("%s named %s", resource, name)
+	default:
+		return resource
+	}
+}
+
+func findZone(cs *cloudstack.CloudStackClient, id string, name string) (string, error) {
+	p := cs.Zone.NewListZonesParams()
+	if id != "" {
+		p.SetId(id)
+	} else if name != "" {
+		p.SetName(name)
+	}
+	resp, err := cs.Zone.ListZones(p)
+	if err != nil {
+		return "", fmt.Errorf("error listing the zones: %w", err)
+	}
+	for _, zone := range resp.Zones {
+		if name != "" && zone.Name != name {
+			continue
+		}
+		if zone.Allocationstate != "Enabled" {
+			if id != "" || name != "" {
+				return "", fmt.Errorf("%s is %s", describe("zone", id, name), zone.Allocationstate)
+			}
+			continue
+		}
+		logPicked("zone", id, name, zone.Id, zone.Name)
+		return zone.Id, nil
+	}
+	return "", fmt.Errorf("no enabled %s found", describe("zone", id, name))
+}
+
+func findNetworkOffering(cs *cloudstack.CloudStackClient, id string, name string) (string, error) {
+	p := cs.NetworkOffering.NewListNetworkOfferingsParams()
+	if id != "" {
+		p.SetId(id)
+	} else if name != "" {
+		p.SetName(name)
+	} else {
+		p.SetGuestiptype("Shared")
+		p.SetSpecifyvlan(true)
+		p.SetSpecifyipranges(true)
+	}
+	resp, err := cs.NetworkOffering.ListNetworkOfferings(p)
+	if err != nil {
+		return "", fmt.Errorf("error listing the network offerings: %w", err)
+	}
+	for _, offering := range resp.NetworkOfferings {
+		if name != "" && offering.Name != name {
+			continue
+		}
+		if offering.State != "Enabled" {
+			if id != "" || name != "" {
+				return "", fmt.Errorf("%s is %s", describe("network offering", id, name), offering.State)
+			}
+			continue
+		}
+		logPicked("network offering", id, name, offering.Id, offering.Name)
+		return offering.Id, nil
+	}
+	return "", fmt.Errorf("no enabled %s found", describe("shared network offering", id, name))
+}
+
+func findVpcOffering(cs *cloudstack.CloudStackClient, id string, name string) (string, error) {
+	p := cs.VPC.NewListVPCOfferingsParams()
+	if id != "" {
+		p.SetId(id)
+	} else if name != "" {
+		p.SetName(name)
+	}
+	resp, err := cs.VPC.ListVPCOfferings(p)
+	if err != nil {
+		return "", fmt.Errorf("error listing the VPC offerings: %w", err)
+	}
+	var picked *cloudstack.VPCOffering
+	for _, offering := range resp.VPCOfferings {
+		if name != "" && offering.Name != name {
+			continue
+		}
+		if offering.State != "Enabled" {
+			if id != "" || name != "" {
+				return "", fmt.Errorf("%s is %s", describe("VPC offering", id, name), offering.State)
+			}
+			continue
+		}
+		if picked == nil || offering.Isdefault && !picked.Isdefault {
+			picked = offering
+		}
+	}
+	if picked == nil {
+		return "", fmt.Errorf("no enabled %s found", describe("VPC offering", id, name))
+	}
+	logPicked("VPC offering", id, name, picked.Id, picked.Name)
+	return picked.Id, nil
+}
+
+func findVpcTierOffering(cs *cloudstack.CloudStackClient, id string, name string) (string, error) {
+	p := cs.NetworkOffering.NewListNetworkOfferingsParams()
+	if id != "" {
+		p.SetId(id)
+	} else if name != "" {
+		p.SetName(name)
+	} else {
+		p.SetGuestiptype("Isolated")
+	}
+	p.SetForvpc(true)
+	resp, err := cs.NetworkOffering.ListNetworkOfferings(p)
+	if err != nil {
+		return "", fmt.Errorf("error listing the network offerings: %w", err)
+	}
+	for _, offering := range resp.NetworkOfferings {
+		if name != "" && offering.Name != name {
+			continue
+		}
+		if offering.State != "Enabled" {
+			if id != "" || name != "" {
+				return "", fmt.Errorf("%s is %s", describe("VPC tier network offering", id, name), offering.State)
+			}
+			continue
+		}
+		logPicked("VPC tier network offering", id, name, offering.Id, offering.Name)
+		return offering.Id, nil
+	}
+	return "", fmt.Errorf("no enabled %s found", describe("VPC tier network offering", id, name))
+}
+
+func findIsolatedOffering(cs *cloudstack.CloudStackClient, id string, name string) (string, error) {
+	p := cs.NetworkOffering.NewListNetworkOfferingsParams()
+	if id != "" {
+		p.SetId(id)
+	} else if name != "" {
+		p.SetName(name)
+	} else {
+		p.SetGuestiptype("Isolated")
+		p.SetForvpc(false)
+		p.SetSourcenatsupported(true)
+		p.SetSpecifyvlan(false)
+	}
+	resp, err := cs.NetworkOffering.ListNetworkOfferings(p)
+	if err != nil {
+		return "", fmt.Errorf("error listing the network offerings: %w", err)
+	}
+	for _, offering := range resp.NetworkOfferings {
+		if name != "" && offering.Name != name {
+			continue
+		}
+		if offering.State != "Enabled" {
+			if id != "" || name != "" {
+				return "", fmt.Errorf("%s is %s", describe("isolated network offering", id, name), offering.State)
+			}
+			continue
+		}
+		logPicked("isolated network offering", id, name, offering.Id, offering.Name)
+		return offering.Id, nil
+	}
+	return "", fmt.Errorf("no enabled %s found", describe("isolated network offering", id, name))
+}
+
+func findServiceOffering(cs *cloudstack.CloudStackClient, id string, name string) (string, error) {
+	p := cs.ServiceOffering.NewListServiceOfferingsParams()
+	if id != "" {
+		p.SetId(id)
+	} else if name != "" {
+		p.SetName(name)
+	} else {
+		p.SetIssystem(false)
+	}
+	resp, err := cs.ServiceOffering.ListServiceOfferings(p)
+	if err != nil {
+		return "", fmt.Errorf("error listing the service offerings: %w", err)
+	}
+	var picked *cloudstack.ServiceOffering
+	for _, offering := range resp.ServiceOfferings {
+		if name != "" && offering.Name != name {
+			continue
+		}
+		if id != "" || name != "" {
+			picked = offering
+			break
+		}
+		if offering.Iscustomized || offering.Issystem {
+			continue
+		}
+		if picked == nil || offering.Cpunumber*offering.Memory < picked.Cpunumber*picked.Memory {
+			picked = offering
+		}
+	}
+	if picked == nil {
+		return "", fmt.Errorf("no %s found", describe("service offering", id, name))
+	}
+	logPicked("service offering", id, name, picked.Id, picked.Name)
+	return picked.Id, nil
+}
+
+func findDiskOffering(cs *cloudstack.CloudStackClient, id string, name string) (string, error) {
+	p := cs.DiskOffering.NewListDiskOfferingsParams()
+	if id != "" {
+		p.SetId(id)
+	} else if name != "" {
+		p.SetName(name)
+	}
+	resp, err := cs.DiskOffering.ListDiskOfferings(p)
+	if err != nil {
+		return "", fmt.Errorf("error listing the disk offerings: %w", err)
+	}
+	var picked *cloudstack.DiskOffering
+	for _, offering := range resp.DiskOfferings {
+		if name != "" && offering.Name != name {
+			continue
+		}
+		if id != "" || name != "" {
+			picked = offering
+			break
+		}
+		if offering.Iscustomized || offering.Disksize <= 0 {
+			continue
+		}
+		if picked == nil || offering.Disksize < picked.Disksize {
+			picked = offering
+		}
+	}
+	if picked == nil {
+		return "", fmt.Errorf("no %s found", describe("fixed size disk offering", id, name))
+	}
+	logPicked("disk offering", id, name, picked.Id, picked.Name)
+	return picked.Id, nil
+}
+
+func findTemplate(cs *cloudstack.CloudStackClient, id string, name string, zoneIds []string) (string, error) {
+	filter := "executable"
+	if id == "" && name == "" {
+		filter = "featured"
+	}
+	p := cs.Template.NewListTemplatesParams(filter)
+	if id != "" {
+		p.SetId(id)
+	} else if name != "" {
+		p.SetName(name)
+	}
+	resp, err := cs.Template.ListTemplates(p)
+	if err != nil {
+		return "", fmt.Errorf("error listing the templates: %w", err)
+	}
+
+	// The templates are listed once per zone, so group the zones they are ready in.
+	var ids []string
+	names := make(map[string]string)
+	readyZones := make(map[string]map[string]bool)
+	for _, template := range resp.Templates {
+		if name != "" && template.Name != name {
+			continue
+		}
+		if template.Templatetype == "SYSTEM" || !template.Bootable {
+			continue
+		}
+		if _, ok := readyZones[template.Id]; !ok {
+			ids = append(ids, template.Id)
+			names[template.Id] = template.Name
+			readyZones[template.Id] = make(map[string]bool)
+		}
+		if template.Isready {
+			readyZones[template.Id][template.Zoneid] = true
+		}
+	}
+
+	for _, templateId := range ids {
+		var notReady []string
+		for _, zoneId := range zoneIds {
+			if !readyZones[templateId][zoneId] {
+				notReady = append(notReady, zoneId)
+			}
+		}
+		if len(readyZones[templateId]) == 0 || len(notReady) > 0 {
+			if id != "" || name != "" {
+				if len(notReady) == 0 {
+					return "", fmt.Errorf("%s is not ready", describe("template", id, name))
+				}
+				return "", fmt.Errorf("%s is not ready in the zones %s", describe("template", id, name), strings.Join(notReady, ", "))
+			}
+			continue
+		}
+		logPicked("template", id, name, templateId, names[templateId])
+		return templateId, nil
+	}
+	return "", fmt.Errorf("no ready %s found", describe("template", id, name))
+}
+
+func logPicked(resource string, id string, name string, pickedId string, pickedName string) {
+	switch {
+	case id != "":
+		log.Infof("Found the %s %s (%s)", resource, pickedName, pickedId)
+	case name != "":
+		log.Infof("Resolved the %s %s to %s", resource, name, pickedId)
+	default:
+		log.Infof("Picked the %s %s (%s)", resource, pickedName, pickedId)
+	}
+}
@@ -0,0 +1,88 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// PlannedOperation is an API call a dry run would have made.
+type PlannedOperation struct {
+	Stage string
+	API   string
+	// Target is the ID of the resource the operation is made on or in, e.g.
+	// the domain a network is created in.
+	Target string
+}
+
+// DryRunReport holds the operations planned by a dry run, in order. It is safe for concurrent use.
+type DryRunReport struct {
+	mu         sync.Mutex
+	Operations []*PlannedOperation
+}
+
+func (r *DryRunReport) add(stage string, api string, target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Operations = append(r.Operations, &PlannedOperation{Stage: stage, API: api, Target: target})
+}
+
+// Table returns the planned operations as a table with a row per stage and
+// API, in the order they would be made, with their count and targets. A target
+// of several operations is listed once, followed by their number.
+func (r *DryRunReport) Table() table.Writer {
+	type row struct {
+		stage, api string
+		count      int
+		targets    []string
+		perTarget  map[string]int
+	}
+	var rows []*row
+	byKey := make(map[string]*row)
+	for _, op := range r.Operations {
+		key := op.Stage + "/" + op.API
+		if byKey[key] == nil {
+			byKey[key] = &row{stage: op.Stage, api: op.API, perTarget: make(map[string]int)}
+			rows = append(rows, byKey[key])
+		}
+		row := byKey[key]
+		row.count++
+		if row.perTarget[op.Target] == 0 {
+			row.targets = append(row.targets, op.Target)
+		}
+		row.perTarget[op.Target]++
+	}
+
+	t := table.NewWriter()
+	t.AppendHeader(table.Row{"Stage", "API", "Count", "Targets"})
+	for _, row := range rows {
+		targets := make([]string, len(row.targets))
+		for i, target := range row.targets {
+			targets[i] = target
+			if n := row.perTarget[target]; n > 1 {
+				targets[i] = fmt.Sprintf("%s (x%d)", target, n)
+			}
+		}
+		t.AppendRow(table.Row{row.stage, row.api, row.count, strings.Join(targets, "\n")})
+	}
+	return t
+}
@@ -0,0 +1,178 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"csbench/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultInventoryFile is where the resources created are recorded by default.
+const DefaultInventoryFile = "csbench-inventory.jsonl"
+
+// The types of the resources recorded in the inventory.
+const (
+	ResourceDomain     = "domain"
+	ResourceAccount    = "account"
+	ResourceNetwork    = "network"
+	ResourceVm         = "vm"
+	ResourceVolume     = "volume"
+	ResourceVpc        = "vpc"
+	ResourcePublicIp   = "publicip"
+	ResourceSnapshot   = "snapshot"
+	ResourceVmSnapshot = "vmsnapshot"
+	ResourceTemplate   = "template"
+	ResourceIso        = "iso"
+)
+
+// InventoryRecord is a resource created by csbench.
+type InventoryRecord struct {
+	RunId     string    `json:"runId"`
+	Type      string    `json:"type"`
+	Id        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	DomainId  string    `json:"domainId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+/*
+Inventory appends a record of every resource created to the inventory file,
+one JSON object per line, as soon as it is created, so that the resources of a
+run which crashed are still known. It is safe for concurrent use, and a nil
+Inventory records nothing.
+*/
+type Inventory struct {
+	mu    sync.Mutex
+	runId string
+	file  *os.File
+}
+
+// newRunId returns an ID for a run, made of its start time and a random suffix.
+func newRunId() string {
+	return time.Now().UTC().Format("20060102-150405") + "-" + utils.RandomString(4)
+}
+
+// openInventory opens the inventory file of the Bench to record the resources
+// of its run, or returns nil if it has no InventoryFile.
+func (b *Bench) openInventory() (*Inventory, error) {
+	if b.InventoryFile == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(b.InventoryFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the inventory file: %w", err)
+	}
+	log.Infof("Recording the resources of run %s in %s", b.cfg.RunId, b.InventoryFile)
+	return &Inventory{runId: b.cfg.RunId, file: f}, nil
+}
+
+// add records a resource. A failure to write the record is logged, as the
+// resource exists anyway.
+func (inv *Inventory) add(resourceType string, id string, name string, domainId string) {
+	if inv == nil {
+		return
+	}
+	data, err := json.Marshal(&InventoryRecord{
+		RunId:     inv.runId,
+		Type:      resourceType,
+		Id:        id,
+		Name:      name,
+		DomainId:  domainId,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err == nil {
+		inv.mu.Lock()
+		_, err = inv.file.Write(append(data, '\n'))
+		inv.mu.Unlock()
+	}
+	if err != nil {
+		log.Warnf("Failed to record %s %s in the inventory: %s", resourceType, id, err)
+	}
+}
+
+// Close closes the inventory file.
+func (inv *Inventory) Close() error {
+	if inv == nil {
+		return nil
+	}
+	return inv.file.Close()
+}
+
+// ReadInventory returns the resources recorded in the inventory file, in the
+// order they were created. A missing file has no records.
+func ReadInventory(path string) ([]*InventoryRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the inventory file: %w", err)
+	}
+	defer f.Close()
+
+	var records []*InventoryRecord
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := &InventoryRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("error reading the inventory file at line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the inventory file: %w", err)
+	}
+	return records, nil
+}
+
+// writeInventory replaces the inventory file with the records, removing it if
+// there are none left.
+func writeInventory(path string, records []*InventoryRecord) error {
+	if len(records) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error removing the inventory file: %w", err)
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	if err := utils.ReplaceFile(path, buf.Bytes()); err != nil {
+		return fmt.Errorf("error writing the inventory file: %w", err)
+	}
+	return nil
+}
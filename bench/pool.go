@@ -0,0 +1,101 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"context"
+	"sync"
+
+	"csbench/dashboard"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	log "github.com/sirupsen/logrus"
+	"github.com/sourcegraph/conc/pool"
+)
+
+/*
+workerPool runs resource operations with a bounded number of goroutines and
+collects their results. No new operation is started once ctx is done, and the
+results of the operations aborted because graceCtx is done are discarded.
+*/
+type workerPool struct {
+	ctx      context.Context
+	graceCtx context.Context
+	pool     *pool.Pool
+	clients  *endpointClients
+	dash     *dashboard.Dashboard
+
+	mu      sync.Mutex
+	results []*Result
+}
+
+func newWorkerPool(ctx context.Context, graceCtx context.Context, workers int, clients *endpointClients, dash *dashboard.Dashboard) *workerPool {
+	return &workerPool{
+		ctx:      ctx,
+		graceCtx: graceCtx,
+		pool:     pool.New().WithMaxGoroutines(workers),
+		clients:  clients,
+		dash:     dash,
+	}
+}
+
+// Go submits a task, reporting its execution to the dashboard. The task is
+// given the client of the next endpoint, which is recorded in its result. It
+// returns false without submitting the task if the context is done.
+func (p *workerPool) Go(command string, task func(cs *cloudstack.CloudStackClient) *Result) bool {
+	if p.ctx.Err() != nil {
+		return false
+	}
+	p.pool.Go(func() {
+		if p.ctx.Err() != nil {
+			return
+		}
+		client := p.clients.Next()
+		p.dash.Begin(command)
+		result := task(client.cs)
+		result.Endpoint = client.endpoint.Name
+		p.dash.End(command, result.Duration, result.Success)
+		if !result.Success && p.graceCtx.Err() != nil {
+			return
+		}
+		p.mu.Lock()
+		p.results = append(p.results, result)
+		p.mu.Unlock()
+	})
+	return true
+}
+
+// Wait waits for the submitted tasks to complete, or for the grace period to
+// expire, and returns the results collected so far.
+func (p *workerPool) Wait() []*Result {
+	done := make(chan struct{})
+	go func() {
+		p.pool.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-p.graceCtx.Done():
+		log.Warn("Timed out waiting for the in-flight operations to complete")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Result(nil), p.results...)
+}
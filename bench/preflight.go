@@ -0,0 +1,573 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"csbench/apirunner"
+	"csbench/config"
+	"csbench/domain"
+	"csbench/failures"
+	"csbench/network"
+	"csbench/utils"
+	"csbench/vm"
+	"csbench/volume"
+	"csbench/vpc"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/jedib0t/go-pretty/v6/table"
+	log "github.com/sirupsen/logrus"
+)
+
+// Statuses of the preflight checks.
+const (
+	CheckGo   = "GO"
+	CheckWarn = "WARN"
+	CheckNoGo = "NO-GO"
+)
+
+// Capacity types reported by listCapacity.
+const (
+	capacityMemory           = 0
+	capacityCPU              = 1
+	capacityStorageAllocated = 3
+)
+
+// The APIs used by the admin profile to create the resources of each stage.
+var stageAPIs = []struct {
+	enabled func(stages Stages) bool
+	apis    []string
+}{
+	{func(s Stages) bool { return s.Domain }, []string{"createDomain", "createAccount"}},
+	{func(s Stages) bool { return s.Limits }, []string{"listAccounts", "updateResourceLimit"}},
+	{func(s Stages) bool { return s.Network }, []string{"createNetwork", "createTags"}},
+	{func(s Stages) bool { return s.Vpc }, []string{"listVPCs", "createVPC", "listNetworkACLLists", "createNetworkACLList", "createNetworkACL", "createNetwork", "createTags"}},
+	{func(s Stages) bool { return s.Isolated }, []string{"listNetworks", "listPublicIpAddresses", "createNetwork", "associateIpAddress", "createTags"}},
+	{func(s Stages) bool { return s.Vm }, []string{"listNetworks", "deployVirtualMachine", "createTags"}},
+	{func(s Stages) bool { return s.StaticNat }, []string{"listPublicIpAddresses", "listVirtualMachines", "enableStaticNat"}},
+	{func(s Stages) bool { return s.Rules }, []string{"listNetworks", "listPublicIpAddresses", "listVirtualMachines", "listFirewallRules", "listEgressFirewallRules",
+		"listPortForwardingRules", "listLoadBalancerRules", "listLoadBalancerRuleInstances", "createFirewallRule", "createEgressFirewallRule",
+		"createPortForwardingRule", "createLoadBalancerRule", "assignToLoadBalancerRule", "createTags"}},
+	{func(s Stages) bool { return s.Volume }, []string{"listVirtualMachines", "createVolume", "createTags", "attachVolume"}},
+	{func(s Stages) bool { return s.Snapshot }, []string{"listVirtualMachines", "listVolumes", "listSnapshots", "listVMSnapshot", "listSnapshotPolicies",
+		"createSnapshotPolicy", "createSnapshot", "createVMSnapshot", "createTags"}},
+	{func(s Stages) bool { return s.Images }, []string{"listAccounts", "listTemplates", "listIsos", "registerTemplate", "registerIso", "createTags"}},
+}
+
+// Check is the outcome of a preflight check.
+type Check struct {
+	Name    string
+	Target  string
+	Status  string
+	Details string
+}
+
+// PreflightReport holds the outcome of all the preflight checks.
+type PreflightReport struct {
+	Checks []*Check
+}
+
+// Go reports whether none of the checks failed.
+func (r *PreflightReport) Go() bool {
+	for _, check := range r.Checks {
+		if check.Status == CheckNoGo {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *PreflightReport) add(name string, target string, status string, format string, a ...interface{}) {
+	r.Checks = append(r.Checks, &Check{Name: name, Target: target, Status: status, Details: fmt.Sprintf(format, a...)})
+}
+
+// Table returns the report as a table with one row per check.
+func (r *PreflightReport) Table() table.Writer {
+	t := table.NewWriter()
+	t.AppendHeader(table.Row{"Check", "Target", "Status", "Details"})
+	for _, check := range r.Checks {
+		t.AppendRow(table.Row{check.Name, check.Target, check.Status, check.Details})
+	}
+	return t
+}
+
+// plan is what the create stages are expected to create.
+type plan struct {
+	domains int
+	// networks is the number of shared networks, which zoneNetworks has per
+	// zone. The VPC tiers and isolated networks do not take from the pools.
+	networks     int
+	zoneNetworks map[string]int
+	vms          map[string]int
+	volumes      map[string]int
+	// skipped lists the subdomains whose resources could not be listed, and
+	// are left out of the plan.
+	skipped []string
+}
+
+/*
+Preflight checks that the environment is ready for the create stages and the
+benchmark scenario, without creating anything:
+  - the url and every endpoint can be reached with the credentials of every
+    profile
+  - the role of every profile allows the commands of the scenario, and the
+    role of the admin profile allows the APIs of the create stages
+  - the zones, offerings and template resolve as described for Discover, which
+    includes the template being ready in the zones
+  - the zones have enough free memory, CPU and primary storage for the VMs and
+    volumes to create
+  - the vlanpool and cidrpool have a free VLAN and subnet for every network to
+    create in its zone
+
+The checks which need the admin profile are skipped if it cannot connect.
+*/
+func (b *Bench) Preflight(ctx context.Context, stages Stages, scenario Scenario) (*PreflightReport, error) {
+	report := &PreflightReport{}
+
+	profileAPIs := make(map[string]map[string]bool)
+	for _, profile := range b.cfg.Profiles {
+		for _, endpoint := range preflightEndpoints(b.cfg) {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			target := fmt.Sprintf("[%s] %s", profile.Name, endpoint.Name)
+			cs := utils.NewAsyncClient(ctx, endpoint.URL, profile.ApiKey, profile.SecretKey)
+			apis, err := listApis(cs)
+			if err != nil {
+				report.add("Credentials", target, CheckNoGo, "%s", failures.FromError("listApis", err).ErrorText)
+				continue
+			}
+			report.add("Credentials", target, CheckGo, "%d APIs allowed", len(apis))
+			if _, ok := profileAPIs[profile.Name]; !ok {
+				profileAPIs[profile.Name] = apis
+			}
+		}
+	}
+
+	commandsFile := scenario.CommandsFile
+	if commandsFile == "" {
+		commandsFile = apirunner.DefaultCommandsFile
+	}
+	commands, _, err := apirunner.ReadCommandsFile(commandsFile)
+	if err != nil {
+		report.add("Permissions", commandsFile, CheckNoGo, "error reading the commands: %s", err)
+	}
+	for _, profile := range b.cfg.Profiles {
+		apis, ok := profileAPIs[profile.Name]
+		if !ok || commands == nil {
+			continue
+		}
+		if missing := missingAPIs(apis, commands); len(missing) > 0 {
+			report.add("Permissions", fmt.Sprintf("[%s] %s", profile.Name, commandsFile), CheckWarn, "not allowed, the calls will fail: %s", strings.Join(missing, ", "))
+		} else {
+			report.add("Permissions", fmt.Sprintf("[%s] %s", profile.Name, commandsFile), CheckGo, "all %d commands allowed", len(commands))
+		}
+	}
+
+	admin, err := b.adminProfile()
+	if err != nil {
+		report.add("Permissions", "[admin]", CheckNoGo, "%s", err)
+		return report, nil
+	}
+	apis, ok := profileAPIs[admin.Name]
+	if !ok {
+		report.add("Environment", "[admin]", CheckNoGo, "skipped, the admin profile cannot connect")
+		return report, nil
+	}
+	var required []string
+	seen := make(map[string]bool)
+	for _, stage := range stageAPIs {
+		if !stage.enabled(stages) {
+			continue
+		}
+		for _, api := range stage.apis {
+			if !seen[api] {
+				seen[api] = true
+				required = append(required, api)
+			}
+		}
+	}
+	if missing := missingAPIs(apis, required); len(missing) > 0 {
+		report.add("Permissions", "[admin] create", CheckNoGo, "not allowed: %s", strings.Join(missing, ", "))
+	} else if len(required) > 0 {
+		report.add("Permissions", "[admin] create", CheckGo, "all %d APIs allowed", len(required))
+	}
+
+	if err := b.Discover(ctx, stages); err != nil {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		for _, err := range unwrapErrors(err) {
+			report.add("Configuration", "", CheckNoGo, "%s", err)
+		}
+		return report, nil
+	}
+	report.add("Configuration", "", CheckGo, "zones, offerings and template found and ready")
+
+	cs := utils.NewAsyncClient(ctx, b.cfg.URL, admin.ApiKey, admin.SecretKey)
+	p, err := b.plan(cs, stages)
+	if err != nil {
+		report.add("Environment", b.cfg.ParentDomainId, CheckNoGo, "error listing the existing resources: %s", failures.FromError("listDomainChildren", err).ErrorText)
+		return report, ctx.Err()
+	}
+	log.Infof("Planned %d domains, %d shared networks, %d VMs and %d volumes", p.domains, p.networks, sum(p.vms), sum(p.volumes))
+	if len(p.skipped) > 0 {
+		report.add("Environment", b.cfg.ParentDomainId, CheckNoGo, "the resources of %d subdomains could not be listed, the plan is incomplete: %s", len(p.skipped), strings.Join(p.skipped, ", "))
+	}
+
+	if stages.Vm || stages.Volume {
+		checkCapacity(cs, b.cfg, p, report)
+	}
+	if stages.Network {
+		checkVlans(cs, b.cfg, p, report)
+	}
+	return report, ctx.Err()
+}
+
+// preflightEndpoints returns the url and the active endpoints.
+func preflightEndpoints(cfg *config.Config) []config.Endpoint {
+	endpoints := []config.Endpoint{{Name: cfg.Host(), URL: cfg.URL}}
+	for _, endpoint := range cfg.ActiveEndpoints() {
+		if endpoint.URL != cfg.URL {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+func listApis(cs *cloudstack.CloudStackClient) (map[string]bool, error) {
+	resp, err := cs.APIDiscovery.ListApis(cs.APIDiscovery.NewListApisParams())
+	if err != nil {
+		return nil, err
+	}
+	apis := make(map[string]bool)
+	for _, api := range resp.Apis {
+		apis[api.Name] = true
+	}
+	return apis, nil
+}
+
+func missingAPIs(apis map[string]bool, required []string) []string {
+	var missing []string
+	for _, api := range required {
+		if !apis[api] {
+			missing = append(missing, api)
+		}
+	}
+	return missing
+}
+
+func unwrapErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+func sum(counts map[string]int) int {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+/*
+plan works out what the stages would create from the existing resources,
+counting them the same way Create does: a shared network for each subdomain
+which has none, up to numvpcs VPCs of numtiers tiers and numisolated isolated
+networks per subdomain spread across the zones, the VMs missing for numvms VMs
+per network and tier in their zone, and the volumes missing for numvolumes
+volumes per VM. The domains the domain stage would create are planned in full.
+As for Create, the subdomains whose resources cannot be listed are skipped and
+recorded in the plan, and an error listing the subdomains is returned.
+*/
+func (b *Bench) plan(cs *cloudstack.CloudStackClient, stages Stages) (*plan, error) {
+	cfg := b.cfg
+	p := &plan{zoneNetworks: make(map[string]int), vms: make(map[string]int), volumes: make(map[string]int)}
+	zone := func(i int) string {
+		return cfg.ZoneIds[i%len(cfg.ZoneIds)]
+	}
+	// newNetwork plans a network to create in the zone, with its VMs unless
+	// its domain has no account to deploy them for.
+	deployable := true
+	newNetwork := func(zoneId string) {
+		if deployable {
+			p.vms[zoneId] += cfg.NumVms
+		}
+	}
+
+	// The existing networks and VMs, and the VMs and volumes in them, across
+	// the subdomains, as counted by Create.
+	var vmNetworks []*cloudstack.Network
+	var volumeVms []*cloudstack.VirtualMachine
+	vmCount := make(map[string]int)
+	volumeCount := make(map[string]int)
+
+	domains, err := listOwnedSubDomains(cs, cfg, cfg.ParentDomainId)
+	if err != nil {
+		return nil, err
+	}
+	// skip records a subdomain left out of the plan, once.
+	skip := func(domainId string) {
+		if n := len(p.skipped); n == 0 || p.skipped[n-1] != domainId {
+			p.skipped = append(p.skipped, domainId)
+		}
+	}
+	for i, dmn := range domains {
+		var networks []*cloudstack.Network
+		if stages.Network || stages.Vpc || stages.Isolated || stages.Vm {
+			all, err := network.ListNetworks(cs, cfg, dmn.Id)
+			if err != nil {
+				log.Warnf("Skipping domain %s, error listing its networks: %s", dmn.Id, err)
+				skip(dmn.Id)
+				continue
+			}
+			networks = ownNetworks(all, dmn.Id)
+		}
+		// The VPCs and isolated networks are owned by the first account of
+		// the domain, and the VMs deployed for the account of the domain.
+		hasAccount := true
+		deployable = true
+		if stages.Vpc || stages.Isolated || stages.Vm {
+			accounts, err := domain.ListAccounts(cs, cfg, dmn.Id)
+			if err != nil {
+				log.Warnf("Skipping domain %s, error listing its accounts: %s", dmn.Id, err)
+				skip(dmn.Id)
+				continue
+			}
+			hasAccount, deployable = len(accounts) > 0, false
+			for _, account := range accounts {
+				deployable = deployable || account.Domainid == dmn.Id
+			}
+		}
+
+		if stages.Network && !hasSharedNetwork(networks) {
+			p.networks++
+			p.zoneNetworks[zone(i)]++
+			newNetwork(zone(i))
+		}
+		if stages.Vpc && hasAccount {
+			vpcs, err := vpc.ListVpcs(cs, cfg, dmn.Id)
+			if err != nil {
+				log.Warnf("Skipping the VPCs of domain %s, error listing them: %s", dmn.Id, err)
+				skip(dmn.Id)
+			} else {
+				tiers := vpcTierGateways(networks)
+				own := 0
+				for _, v := range vpcs {
+					if v.Domainid != dmn.Id {
+						continue
+					}
+					own++
+					for j := len(tiers[v.Id]); j < cfg.NumTiers; j++ {
+						newNetwork(v.Zoneid)
+					}
+				}
+				for j := own; j < cfg.NumVpcs; j++ {
+					for k := 0; k < cfg.NumTiers; k++ {
+						newNetwork(zone(i + j))
+					}
+				}
+			}
+		}
+		if stages.Isolated && hasAccount {
+			for j := len(isolatedNetworks(networks)); j < cfg.NumIsolated; j++ {
+				newNetwork(zone(i + j))
+			}
+		}
+
+		var vms []*cloudstack.VirtualMachine
+		if stages.Vm || stages.Volume {
+			var err error
+			if vms, err = vm.ListVMs(cs, cfg, dmn.Id); err != nil {
+				log.Warnf("Skipping domain %s, error listing its VMs: %s", dmn.Id, err)
+				skip(dmn.Id)
+				continue
+			}
+		}
+		if stages.Vm && deployable {
+			for networkId, count := range networkVmCounts(vms) {
+				vmCount[networkId] += count
+			}
+			vmNetworks = append(vmNetworks, networks...)
+		}
+		if stages.Volume {
+			volumes, err := volume.ListDataVolumes(cs, cfg, dmn.Id)
+			if err != nil {
+				log.Warnf("Skipping the volumes of domain %s, error listing them: %s", dmn.Id, err)
+				skip(dmn.Id)
+				continue
+			}
+			for vmId, count := range vmVolumeCounts(volumes) {
+				volumeCount[vmId] += count
+			}
+			for _, v := range vms {
+				if volumeVm(v.State) {
+					volumeVms = append(volumeVms, v)
+				}
+			}
+		}
+	}
+	for _, n := range vmNetworks {
+		if missing := cfg.NumVms - vmCount[n.Id]; missing > 0 {
+			p.vms[n.Zoneid] += missing
+		}
+	}
+	for _, v := range volumeVms {
+		if missing := cfg.NumVolumes - volumeCount[v.Id]; missing > 0 {
+			p.volumes[v.Zoneid] += missing
+		}
+	}
+
+	if stages.Domain {
+		deployable = true
+		p.domains = cfg.DomainTreeSize()
+		for i := len(domains); i < len(domains)+p.domains; i++ {
+			if stages.Network {
+				p.networks++
+				p.zoneNetworks[zone(i)]++
+				newNetwork(zone(i))
+			}
+			for j := 0; stages.Vpc && j < cfg.NumVpcs; j++ {
+				for k := 0; k < cfg.NumTiers; k++ {
+					newNetwork(zone(i + j))
+				}
+			}
+			for j := 0; stages.Isolated && j < cfg.NumIsolated; j++ {
+				newNetwork(zone(i + j))
+			}
+		}
+	}
+
+	if !stages.Vm {
+		p.vms = make(map[string]int)
+	}
+	if stages.Volume {
+		// The VMs to deploy get all their volumes.
+		for zoneId, count := range p.vms {
+			p.volumes[zoneId] += count * cfg.NumVolumes
+		}
+	}
+	return p, nil
+}
+
+func checkCapacity(cs *cloudstack.CloudStackClient, cfg *config.Config, p *plan, report *PreflightReport) {
+	var memory, cpu, disk int64
+	if cfg.ServiceOfferingId != "" && sum(p.vms) > 0 {
+		offering, _, err := cs.ServiceOffering.GetServiceOfferingByID(cfg.ServiceOfferingId)
+		if err != nil {
+			report.add("Capacity", "", CheckNoGo, "error getting the service offering: %s", err)
+			return
+		}
+		memory = int64(offering.Memory) * 1024 * 1024
+		cpu = int64(offering.Cpunumber) * int64(offering.Cpuspeed)
+	}
+	if cfg.DiskOfferingId != "" && sum(p.volumes) > 0 {
+		offering, _, err := cs.DiskOffering.GetDiskOfferingByID(cfg.DiskOfferingId)
+		if err != nil {
+			report.add("Capacity", "", CheckNoGo, "error getting the disk offering: %s", err)
+			return
+		}
+		disk = offering.Disksize * 1024 * 1024 * 1024
+	}
+
+	zoneIds := make(map[string]bool)
+	for zoneId := range p.vms {
+		zoneIds[zoneId] = true
+	}
+	for zoneId := range p.volumes {
+		zoneIds[zoneId] = true
+	}
+	for _, zoneId := range sortedKeys(zoneIds) {
+		params := cs.SystemCapacity.NewListCapacityParams()
+		params.SetZoneid(zoneId)
+		params.SetFetchlatest(true)
+		resp, err := cs.SystemCapacity.ListCapacity(params)
+		if err != nil {
+			report.add("Capacity", zoneId, CheckNoGo, "error listing the capacity: %s", err)
+			continue
+		}
+		free := make(map[int]int64)
+		total := make(map[int]int64)
+		for _, capacity := range resp.Capacity {
+			free[capacity.Type] += capacity.Capacitytotal - capacity.Capacityused
+			total[capacity.Type] += capacity.Capacitytotal
+		}
+		needs := []struct {
+			name     string
+			capacity int
+			need     int64
+			unit     string
+			scale    int64
+		}{
+			{"memory", capacityMemory, int64(p.vms[zoneId]) * memory, "GiB", 1024 * 1024 * 1024},
+			{"CPU", capacityCPU, int64(p.vms[zoneId]) * cpu, "GHz", 1000},
+			{"primary storage", capacityStorageAllocated, int64(p.volumes[zoneId]) * disk, "GiB", 1024 * 1024 * 1024},
+		}
+		for _, n := range needs {
+			if n.need == 0 {
+				continue
+			}
+			status := CheckGo
+			if n.need > free[n.capacity] {
+				status = CheckNoGo
+			}
+			report.add("Capacity", zoneId, status, "%s: need %.1f %s, free %.1f of %.1f %s", n.name,
+				float64(n.need)/float64(n.scale), n.unit, float64(free[n.capacity])/float64(n.scale), float64(total[n.capacity])/float64(n.scale), n.unit)
+		}
+	}
+}
+
+// checkVlans checks that the VLAN and subnet pools have room for the networks
+// to create in every zone, leaving out the ones of the existing networks.
+func checkVlans(cs *cloudstack.CloudStackClient, cfg *config.Config, p *plan, report *PreflightReport) {
+	for _, zoneId := range sortedKeys(p.zoneNetworks) {
+		networks, err := network.ListZoneNetworks(cs, cfg, zoneId)
+		if err != nil {
+			report.add("VLANs", zoneId, CheckNoGo, "error listing the networks: %s", err)
+			continue
+		}
+		allocator := network.NewAllocator(cfg, networks)
+		need := p.zoneNetworks[zoneId]
+		allocated := 0
+		for ; allocated < need; allocated++ {
+			if _, err = allocator.Next(); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			report.add("VLANs", zoneId, CheckNoGo, "only %d of %d networks fit: %s", allocated, need, err)
+		} else {
+			report.add("VLANs", zoneId, CheckGo, "%d VLANs and subnets free", need)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
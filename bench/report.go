@@ -0,0 +1,168 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"csbench/failures"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/montanaflynn/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// Number of distinct errors shown per API in the error summary
+const topErrorsPerAPI = 5
+
+func getSamples(results []*Result) (stats.Float64Data, stats.Float64Data, stats.Float64Data) {
+	var allExecutionsSample stats.Float64Data
+	var successfulExecutionSample stats.Float64Data
+	var failedExecutionSample stats.Float64Data
+
+	for _, result := range results {
+		duration := math.Round(result.Duration*1000) / 1000
+		allExecutionsSample = append(allExecutionsSample, duration)
+		if result.Success {
+			successfulExecutionSample = append(successfulExecutionSample, duration)
+		} else {
+			failedExecutionSample = append(failedExecutionSample, duration)
+		}
+	}
+
+	return allExecutionsSample, successfulExecutionSample, failedExecutionSample
+}
+
+func getRowFromSample(key string, sample stats.Float64Data) table.Row {
+	min, _ := sample.Min()
+	min = math.Round(min*1000) / 1000
+	max, _ := sample.Max()
+	max = math.Round(max*1000) / 1000
+	mean, _ := sample.Mean()
+	mean = math.Round(mean*1000) / 1000
+	median, _ := sample.Median()
+	median = math.Round(median*1000) / 1000
+	percentile90, _ := sample.Percentile(90)
+	percentile90 = math.Round(percentile90*1000) / 1000
+	percentile95, _ := sample.Percentile(95)
+	percentile95 = math.Round(percentile95*1000) / 1000
+	percentile99, _ := sample.Percentile(99)
+	percentile99 = math.Round(percentile99*1000) / 1000
+
+	return table.Row{key, len(sample), min, max, mean, median, percentile90, percentile95, percentile99}
+}
+
+// ErrorSummaryTable builds a table with the most frequent errors per resource type and API.
+func ErrorSummaryTable(errors []*failures.Failure) table.Writer {
+	t := table.NewWriter()
+	t.AppendHeader(table.Row{"Resource", "API", "Count", "HTTP status", "Error code", "CS error code", "Error text"})
+	for _, summary := range failures.Summarize(errors, topErrorsPerAPI) {
+		t.AppendRow(table.Row{summary.Resource, summary.API, summary.Count, summary.HTTPStatus, summary.ErrorCode, summary.CSErrorCode, summary.ErrorText})
+	}
+	return t
+}
+
+// groupByEndpoint groups the results by endpoint, and returns the sorted endpoint
+// names. The results of the listings made before an operation is sent, which
+// have no endpoint, are left out.
+func groupByEndpoint(results []*Result) (map[string][]*Result, []string) {
+	grouped := make(map[string][]*Result)
+	var endpoints []string
+	for _, result := range results {
+		if result.Endpoint == "" {
+			continue
+		}
+		if _, ok := grouped[result.Endpoint]; !ok {
+			endpoints = append(endpoints, result.Endpoint)
+		}
+		grouped[result.Endpoint] = append(grouped[result.Endpoint], result)
+	}
+	sort.Strings(endpoints)
+	return grouped, endpoints
+}
+
+/*
+WriteReport writes a report of the results with the following details:
+ 1. Total Number of executions
+ 2. Number of successful executions
+ 3. Number of failed exections
+ 4. Different statistics like min, max, avg, median, 90th percentile, 95th percentile, 99th percentile for above 3
+ 5. The statistics of all executions per endpoint, if more than one endpoint was used
+ 6. The most frequent errors per resource type and API, if any
+
+The table report of an interrupted run is marked as partial. The CSV and TSV
+reports are left machine-readable, and the interruption is logged instead.
+
+Output format:
+ 1. CSV
+ 2. TSV
+ 3. Table
+*/
+func WriteReport(results Results, format string, out io.Writer, interrupted bool) {
+	if interrupted {
+		if format == "table" {
+			fmt.Fprintf(out, "Run interrupted, the results are partial\n")
+		} else {
+			log.Warn("Run interrupted, the results in the report are partial")
+		}
+	}
+
+	t := table.NewWriter()
+	t.AppendHeader(table.Row{"Type", "Count", "Min", "Max", "Avg", "Median", "90th percentile", "95th percentile", "99th percentile"})
+
+	for key, result := range results {
+		allExecutionsSample, successfulExecutionSample, failedExecutionSample := getSamples(result)
+		t.AppendRow(getRowFromSample(fmt.Sprintf("%s - All", key), allExecutionsSample))
+
+		if failedExecutionSample.Len() != 0 {
+			t.AppendRow(getRowFromSample(fmt.Sprintf("%s - Successful", key), successfulExecutionSample))
+			t.AppendRow(getRowFromSample(fmt.Sprintf("%s - Failed", key), failedExecutionSample))
+		}
+
+		byEndpoint, endpoints := groupByEndpoint(result)
+		if len(endpoints) > 1 {
+			for _, endpoint := range endpoints {
+				allExecutionsSample, _, _ := getSamples(byEndpoint[endpoint])
+				t.AppendRow(getRowFromSample(fmt.Sprintf("%s - %s", key, endpoint), allExecutionsSample))
+			}
+		}
+	}
+
+	tables := []table.Writer{t}
+	if errors := results.Failures(); len(errors) > 0 {
+		tables = append(tables, ErrorSummaryTable(errors))
+	}
+
+	for i, t := range tables {
+		if i > 0 {
+			fmt.Fprintf(out, "\nError summary:\n")
+		}
+		t.SetOutputMirror(out)
+		switch format {
+		case "csv":
+			t.RenderCSV()
+		case "tsv":
+			t.RenderTSV()
+		case "table":
+			t.Render()
+		}
+	}
+}
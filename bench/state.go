@@ -0,0 +1,120 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"csbench/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultStateFile is where the progress of the creation stages is saved by default.
+const DefaultStateFile = "csbench-state.json"
+
+// CreateState records the progress of the creation stages, so that a run which
+// failed or was interrupted can be resumed.
+type CreateState struct {
+	ParentDomainId string                 `json:"parentDomainId"`
+	Stages         map[string]*StageState `json:"stages"`
+}
+
+// StageState is the progress of a creation stage.
+type StageState struct {
+	// Target describes the settings the stage converged to. A completed stage
+	// is run again when they change.
+	Target string `json:"target"`
+	// Done is set once a run of the stage finished without any failure.
+	Done      bool      `json:"done"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+/*
+readState reads the state saved at path. A new state is returned if there is
+no file yet, or if the state was saved for another parent domain, in which case
+the stages are all run again.
+*/
+func readState(path string, parentDomainId string) (*CreateState, error) {
+	state := &CreateState{ParentDomainId: parentDomainId, Stages: make(map[string]*StageState)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the state file %s: %w", path, err)
+	}
+	var saved CreateState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("error reading the state file %s: %w", path, err)
+	}
+	if saved.ParentDomainId != parentDomainId {
+		log.Warnf("Ignoring the state file %s saved for the parent domain %s", path, saved.ParentDomainId)
+		return state, nil
+	}
+	if saved.Stages != nil {
+		state.Stages = saved.Stages
+	}
+	return state, nil
+}
+
+// completed returns whether a previous run completed the stage for the same target.
+func (s *CreateState) completed(stage string, target string) bool {
+	st := s.Stages[stage]
+	return st != nil && st.Done && st.Target == target
+}
+
+// record updates the state of the stage with the results of its run.
+func (s *CreateState) record(stage string, target string, results []*Result, interrupted bool) {
+	st := &StageState{Target: target, UpdatedAt: time.Now()}
+	for _, result := range results {
+		if result.Success {
+			st.Succeeded++
+		} else {
+			st.Failed++
+		}
+	}
+	st.Done = st.Failed == 0 && !interrupted
+	s.Stages[stage] = st
+}
+
+// reset marks the stage as to be run again.
+func (s *CreateState) reset(stage string) {
+	if st := s.Stages[stage]; st != nil {
+		st.Done = false
+	}
+}
+
+// write saves the state to path, replacing the file atomically.
+func (s *CreateState) write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.ReplaceFile(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing the state file %s: %w", path, err)
+	}
+	return nil
+}
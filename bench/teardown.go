@@ -0,0 +1,369 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"csbench/address"
+	"csbench/config"
+	"csbench/domain"
+	"csbench/failures"
+	"csbench/image"
+	"csbench/network"
+	"csbench/snapshot"
+	"csbench/tags"
+	"csbench/utils"
+	"csbench/vm"
+	"csbench/volume"
+	"csbench/vpc"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	log "github.com/sirupsen/logrus"
+)
+
+// teardownOrder is the order the resource types are deleted in, the ones
+// depending on others first.
+var teardownOrder = []string{ResourceVmSnapshot, ResourceSnapshot, ResourceVolume, ResourceVm, ResourceTemplate, ResourceIso, ResourcePublicIp, ResourceNetwork, ResourceVpc, ResourceAccount, ResourceDomain}
+
+/*
+TearDown deletes the resources recorded in the inventory file, and only those,
+using the admin profile and running up to workers deletions in parallel, and
+returns the results of every deletion keyed by the resource type. The stages
+select the types of resources to delete, as for Create: the domain stage
+deletes the accounts and the domains, and all the types are deleted if no
+stage is selected. The limits, staticnat and rules stages have nothing to
+delete, the isolated stage deletes the public IPs, releasing their static NAT
+and rules, the snapshot stage deletes the volume and VM snapshots, and the
+images stage the templates and ISOs. The VPC tiers and isolated networks are
+networks, deleted by the network stage, and a VPC can only be deleted once its
+tiers are.
+
+If runId is set, only the resources of that run are deleted, along with the
+VMs, volumes, snapshots, templates, ISOs, networks, VPCs and public IPs tagged
+with the run ID which are not in the inventory, e.g. as they were recorded in
+another inventory file.
+
+The types are deleted in the order vmsnapshot, snapshot, volume, vm, template,
+iso, publicip, network, vpc, account and domain, the last created first within
+each type, and the domains level by level from the deepest one. The resources
+deleted, or found to be gone already, are removed from the inventory, and the
+ones which failed to be deleted are kept for the next TearDown. Cancellation is
+handled as for Create.
+*/
+func (b *Bench) TearDown(ctx context.Context, stages Stages, runId string, workers int) (Results, error) {
+	return b.tearDown(ctx, stages, runId, workers, nil)
+}
+
+// PlanTearDown returns the operations TearDown would make, after reading the
+// inventory and listing the resources tagged with the run ID if it is set,
+// without deleting anything or changing the inventory.
+func (b *Bench) PlanTearDown(ctx context.Context, stages Stages, runId string) (*DryRunReport, error) {
+	report := &DryRunReport{}
+	_, err := b.tearDown(ctx, stages, runId, 1, report)
+	return report, err
+}
+
+// tearDown runs TearDown, or plans it if report is not nil.
+func (b *Bench) tearDown(ctx context.Context, stages Stages, runId string, workers int, report *DryRunReport) (Results, error) {
+	profile, err := b.adminProfile()
+	if err != nil {
+		return nil, err
+	}
+	if b.InventoryFile == "" {
+		return nil, fmt.Errorf("no inventory file to read the resources to delete from")
+	}
+	records, err := ReadInventory(b.InventoryFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if stages == (Stages{}) {
+		stages = Stages{Domain: true, Network: true, Vpc: true, Isolated: true, Vm: true, Volume: true, Snapshot: true, Images: true}
+	}
+	selected := map[string]bool{
+		ResourceVmSnapshot: stages.Snapshot,
+		ResourceSnapshot:   stages.Snapshot,
+		ResourceVolume:     stages.Volume,
+		ResourceVm:         stages.Vm,
+		ResourceTemplate:   stages.Images,
+		ResourceIso:        stages.Images,
+		ResourceNetwork:    stages.Network,
+		ResourceVpc:        stages.Vpc,
+		ResourcePublicIp:   stages.Isolated,
+		ResourceAccount:    stages.Domain,
+		ResourceDomain:     stages.Domain,
+	}
+
+	graceCtx, cancel := utils.WithGracePeriod(ctx, b.ShutdownTimeout)
+	defer cancel()
+
+	clients := newEndpointClients(graceCtx, createEndpoints(b.cfg), profile)
+	targets := records
+	if runId != "" {
+		targets = nil
+		for _, record := range records {
+			if record.RunId == runId {
+				targets = append(targets, record)
+			}
+		}
+		tagged, err := taggedRecords(clients.primary(), b.cfg, runId, targets)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, tagged...)
+	}
+	if len(targets) == 0 && runId != "" {
+		log.Infof("No resources of the run %s found, nothing to tear down", runId)
+		return nil, nil
+	}
+	if len(targets) == 0 {
+		log.Infof("No resources recorded in %s, nothing to tear down", b.InventoryFile)
+		return nil, nil
+	}
+	dash := b.Dashboard
+	newPool := func() *workerPool {
+		return newWorkerPool(ctx, graceCtx, workers, clients, dash)
+	}
+
+	var mu sync.Mutex
+	deleted := make(map[*InventoryRecord]bool)
+	results := make(Results)
+	for _, resourceType := range teardownOrder {
+		if !selected[resourceType] {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		batches := teardownBatches(targets, resourceType)
+		total := 0
+		for _, batch := range batches {
+			total += len(batch)
+		}
+		if total == 0 {
+			continue
+		}
+		log.Infof("Deleting %d resources of type %s", total, resourceType)
+		if report != nil {
+			for _, batch := range batches {
+				for _, record := range batch {
+					if resourceType == ResourceVolume {
+						report.add(resourceType, "detachVolume", record.Id)
+					}
+					report.add(resourceType, deleteAPIs[resourceType], record.Id)
+				}
+			}
+			continue
+		}
+		start := time.Now()
+		dash.AddTotal(total)
+		for _, batch := range batches {
+			workerPool := newPool()
+			for _, record := range batch {
+				record := record
+				submitted := workerPool.Go(resourceType, func(cs *cloudstack.CloudStackClient) *Result {
+					taskStart := time.Now()
+					api, err := deleteResource(cs, record)
+					if err == nil || gone(err) {
+						mu.Lock()
+						deleted[record] = true
+						mu.Unlock()
+					}
+					return newResult(taskStart, api, err)
+				})
+				if !submitted {
+					break
+				}
+			}
+			results[resourceType] = append(results[resourceType], workerPool.Wait()...)
+		}
+		failed := 0
+		for _, result := range results[resourceType] {
+			if !result.Success {
+				failed++
+			}
+		}
+		attempted := len(results[resourceType])
+		if failed == 0 {
+			log.Infof("Deleted %d resources of type %s in %.2f seconds", attempted, resourceType, time.Since(start).Seconds())
+		} else {
+			log.Warnf("Deleted %d resources of type %s in %.2f seconds, attempted %d, failed %d", attempted-failed, resourceType, time.Since(start).Seconds(), attempted, failed)
+		}
+	}
+
+	if report != nil {
+		return nil, ctx.Err()
+	}
+
+	var remaining []*InventoryRecord
+	for _, record := range records {
+		if !deleted[record] {
+			remaining = append(remaining, record)
+		}
+	}
+	if err := writeInventory(b.InventoryFile, remaining); err != nil {
+		return results, err
+	}
+	if len(remaining) > 0 && ctx.Err() == nil {
+		log.Infof("%d resources are left in %s", len(remaining), b.InventoryFile)
+	}
+	return results, ctx.Err()
+}
+
+// taggedRecords returns the VMs, volumes, snapshots, templates, ISOs, networks,
+// VPCs and public IPs tagged with the run ID which are not in the records.
+func taggedRecords(cs *cloudstack.CloudStackClient, cfg *config.Config, runId string, records []*InventoryRecord) ([]*InventoryRecord, error) {
+	found, err := tags.ListTagged(cs, cfg, config.TagRunId, runId)
+	if err != nil {
+		return nil, fmt.Errorf("error listing the resources tagged with the run %s: %w", runId, err)
+	}
+	resourceTypes := map[string]string{
+		tags.ResourceTypeVm:         ResourceVm,
+		tags.ResourceTypeVolume:     ResourceVolume,
+		tags.ResourceTypeNetwork:    ResourceNetwork,
+		tags.ResourceTypeVpc:        ResourceVpc,
+		tags.ResourceTypePublicIp:   ResourcePublicIp,
+		tags.ResourceTypeSnapshot:   ResourceSnapshot,
+		tags.ResourceTypeVmSnapshot: ResourceVmSnapshot,
+		tags.ResourceTypeTemplate:   ResourceTemplate,
+		tags.ResourceTypeIso:        ResourceIso,
+	}
+	known := make(map[string]bool)
+	for _, record := range records {
+		known[record.Id] = true
+	}
+	var tagged []*InventoryRecord
+	for _, tag := range found {
+		resourceType, ok := resourceTypes[tag.Resourcetype]
+		if !ok || known[tag.Resourceid] {
+			continue
+		}
+		known[tag.Resourceid] = true
+		tagged = append(tagged, &InventoryRecord{RunId: runId, Type: resourceType, Id: tag.Resourceid, DomainId: tag.Domainid})
+	}
+	if len(tagged) > 0 {
+		log.Infof("Found %d resources tagged with the run %s which are not in the inventory", len(tagged), runId)
+	}
+	return tagged, nil
+}
+
+/*
+teardownBatches returns the records of the resource type in the batches they
+are deleted in, one after the other, the last created first. The domains are
+batched per level, from the deepest one, so that a domain is only deleted once
+its subdomains are. Every other type is a single batch.
+*/
+func teardownBatches(records []*InventoryRecord, resourceType string) [][]*InventoryRecord {
+	var ofType []*InventoryRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Type == resourceType {
+			ofType = append(ofType, records[i])
+		}
+	}
+	if resourceType != ResourceDomain {
+		return [][]*InventoryRecord{ofType}
+	}
+
+	parents := make(map[string]string)
+	for _, record := range ofType {
+		parents[record.Id] = record.DomainId
+	}
+	depth := func(id string) int {
+		d := 0
+		for parent, ok := parents[id]; ok && d < len(parents); parent, ok = parents[parent] {
+			d++
+		}
+		return d
+	}
+	var batches [][]*InventoryRecord
+	for _, record := range ofType {
+		d := depth(record.Id)
+		for len(batches) < d {
+			batches = append(batches, nil)
+		}
+		batches[d-1] = append(batches[d-1], record)
+	}
+	for i, j := 0, len(batches)-1; i < j; i, j = i+1, j-1 {
+		batches[i], batches[j] = batches[j], batches[i]
+	}
+	return batches
+}
+
+// deleteAPIs are the APIs deleting each type of resource.
+var deleteAPIs = map[string]string{
+	ResourceVmSnapshot: "deleteVMSnapshot",
+	ResourceSnapshot:   "deleteSnapshot",
+	ResourceVolume:     "destroyVolume",
+	ResourceVm:         "destroyVirtualMachine",
+	ResourceTemplate:   "deleteTemplate",
+	ResourceIso:        "deleteIso",
+	ResourceNetwork:    "deleteNetwork",
+	ResourceVpc:        "deleteVPC",
+	ResourcePublicIp:   "disassociateIpAddress",
+	ResourceAccount:    "deleteAccount",
+	ResourceDomain:     "deleteDomain",
+}
+
+// deleteResource deletes the resource of the record, and returns the API
+// deleting it.
+func deleteResource(cs *cloudstack.CloudStackClient, record *InventoryRecord) (string, error) {
+	var err error
+	switch record.Type {
+	case ResourceVmSnapshot:
+		_, err = snapshot.DeleteVmSnapshot(cs, record.Id)
+	case ResourceSnapshot:
+		_, err = snapshot.DeleteSnapshot(cs, record.Id)
+	case ResourceVolume:
+		// A volume has to be detached before it can be destroyed. It is not
+		// attached anymore if its VM was destroyed.
+		volume.DetachVolume(cs, record.Id)
+		_, err = volume.DestroyVolume(cs, record.Id)
+	case ResourceVm:
+		_, err = vm.DestroyVm(cs, record.Id)
+	case ResourceTemplate:
+		_, err = image.DeleteTemplate(cs, record.Id)
+	case ResourceIso:
+		_, err = image.DeleteIso(cs, record.Id)
+	case ResourceNetwork:
+		_, err = network.DeleteNetwork(cs, record.Id)
+	case ResourceVpc:
+		_, err = vpc.DeleteVpc(cs, record.Id)
+	case ResourcePublicIp:
+		_, err = address.DisassociateIp(cs, record.Id)
+	case ResourceAccount:
+		_, err = domain.DeleteAccount(cs, record.Id)
+	case ResourceDomain:
+		_, err = domain.DeleteDomain(cs, record.Id)
+	default:
+		err = fmt.Errorf("unknown resource type %s of %s", record.Type, record.Id)
+	}
+	return deleteAPIs[record.Type], err
+}
+
+// gone returns whether the deletion of a resource failed as it does not exist
+// anymore, e.g. as it was deleted by hand.
+func gone(err error) bool {
+	f := failures.FromError("", err)
+	text := strings.ToLower(f.ErrorText)
+	return f.ErrorCode == 431 && (strings.Contains(text, "does not exist") || strings.Contains(text, "unable to find"))
+}
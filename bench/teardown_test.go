@@ -0,0 +1,65 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTeardownBatches(t *testing.T) {
+	// The records in the order they were created, under the parent domain p.
+	records := []*InventoryRecord{
+		{Type: ResourceDomain, Id: "a", DomainId: "p"},
+		{Type: ResourceDomain, Id: "d", DomainId: "p"},
+		{Type: ResourceAccount, Id: "acct1", DomainId: "a"},
+		{Type: ResourceDomain, Id: "b", DomainId: "a"},
+		{Type: ResourceDomain, Id: "e", DomainId: "a"},
+		{Type: ResourceNetwork, Id: "net1", DomainId: "b"},
+		{Type: ResourceDomain, Id: "c", DomainId: "b"},
+		{Type: ResourceAccount, Id: "acct2", DomainId: "c"},
+	}
+	ids := func(batches [][]*InventoryRecord) [][]string {
+		var got [][]string
+		for _, batch := range batches {
+			var batchIds []string
+			for _, record := range batch {
+				batchIds = append(batchIds, record.Id)
+			}
+			got = append(got, batchIds)
+		}
+		return got
+	}
+
+	tests := []struct {
+		resourceType string
+		want         [][]string
+	}{
+		{ResourceDomain, [][]string{{"c"}, {"e", "b"}, {"d", "a"}}},
+		{ResourceAccount, [][]string{{"acct2", "acct1"}}},
+		{ResourceNetwork, [][]string{{"net1"}}},
+		{ResourceVm, [][]string{nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resourceType, func(t *testing.T) {
+			if got := ids(teardownBatches(records, tt.resourceType)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("teardownBatches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
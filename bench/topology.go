@@ -0,0 +1,363 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"csbench/domain"
+	"csbench/network"
+	"csbench/tags"
+	"csbench/topology"
+	"csbench/utils"
+	"csbench/vm"
+	"csbench/volume"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	log "github.com/sirupsen/logrus"
+)
+
+// The resources created by CreateTopology, passed on to the next step.
+type (
+	topologyDomain struct {
+		id   string
+		spec *topology.Domain
+	}
+	topologyAccount struct {
+		name     string
+		domainId string
+		spec     *topology.Account
+	}
+	topologyNetwork struct {
+		id       string
+		zoneId   string
+		domainId string
+		account  string
+		spec     *topology.Network
+	}
+	topologyVm struct {
+		id       string
+		zoneId   string
+		domainId string
+		account  string
+		group    *topology.VmGroup
+	}
+)
+
+// created collects the resources created by concurrent tasks.
+type created[T any] struct {
+	mu    sync.Mutex
+	items []T
+}
+
+func (c *created[T]) add(item T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = append(c.items, item)
+}
+
+/*
+CreateTopology creates the environment described by the topology under the
+parent domain using the admin profile, running up to workers operations in
+parallel, and returns the results of every operation keyed by the resource
+type. The domains are created level by level, followed by the accounts, the
+additional users, the networks, the VMs and the volumes of the domains created.
+
+The zones, offerings and template are resolved and checked before anything is
+created, as done by Create, along with the offerings named in the topology.
+The VLANs and subnets of the shared networks are allocated from the pools of
+the configuration, skipping the ones already used in their zone.
+Cancellation is handled as for Create.
+*/
+func (b *Bench) CreateTopology(ctx context.Context, topo *topology.Topology, workers int) (Results, error) {
+	profile, err := b.adminProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	totals := topo.Totals()
+	stages := Stages{Network: totals.Networks > 0, Vm: totals.Vms > 0, Volume: totals.Volumes > 0}
+	if err := b.Discover(ctx, stages); err != nil {
+		return nil, fmt.Errorf("error validating the configuration: %w", err)
+	}
+	cs := utils.NewAsyncClient(ctx, b.cfg.URL, profile.ApiKey, profile.SecretKey)
+	serviceOfferings, err := resolveOfferings(cs, topo.ServiceOfferings(), b.cfg.ServiceOfferingId, findServiceOffering)
+	if err != nil {
+		return nil, fmt.Errorf("error validating the topology: %w", err)
+	}
+	diskOfferings, err := resolveOfferings(cs, topo.DiskOfferings(), b.cfg.DiskOfferingId, findDiskOffering)
+	if err != nil {
+		return nil, fmt.Errorf("error validating the topology: %w", err)
+	}
+	var allocators map[string]*network.Allocator
+	if totals.Networks > 0 {
+		if allocators, err = zoneAllocators(cs, b.cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	inv, err := b.openInventory()
+	if err != nil {
+		return nil, err
+	}
+	defer inv.Close()
+
+	graceCtx, cancel := utils.WithGracePeriod(ctx, b.ShutdownTimeout)
+	defer cancel()
+	clients := newEndpointClients(graceCtx, createEndpoints(b.cfg), profile)
+	dash := b.Dashboard
+	dash.AddTotal(totals.Domains + totals.Users + totals.Networks + totals.Vms + totals.Volumes)
+	log.Infof("Creating %d domains, %d accounts, %d users, %d networks, %d VMs and %d volumes",
+		totals.Domains, totals.Accounts, totals.Users, totals.Networks, totals.Vms, totals.Volumes)
+
+	results := make(Results)
+	newPool := func() *workerPool {
+		return newWorkerPool(ctx, graceCtx, workers, clients, dash)
+	}
+
+	// Domains, level by level as the subdomains need their parent.
+	type pendingDomain struct {
+		parentId string
+		spec     *topology.Domain
+	}
+	var pending []pendingDomain
+	for _, spec := range topo.Domains {
+		pending = append(pending, pendingDomain{b.cfg.ParentDomainId, spec})
+	}
+	var domains []topologyDomain
+	for level := 1; len(pending) > 0; level++ {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		start := time.Now()
+		var levelDomains created[topologyDomain]
+		workerPool := newPool()
+	level:
+		for _, p := range pending {
+			p := p
+			for i := 0; i < p.spec.Count; i++ {
+				submitted := workerPool.Go("domain", func(cs *cloudstack.CloudStackClient) *Result {
+					taskStart := time.Now()
+					dmn, err := domain.CreateDomain(cs, b.cfg, p.parentId)
+					if err == nil {
+						inv.add(ResourceDomain, dmn.Id, dmn.Name, p.parentId)
+						levelDomains.add(topologyDomain{dmn.Id, p.spec})
+					}
+					return newResult(taskStart, "createDomain", err)
+				})
+				if !submitted {
+					break level
+				}
+			}
+		}
+		results["domain"] = append(results["domain"], workerPool.Wait()...)
+		log.Infof("Created %d domains at level %d in %.2f seconds", len(levelDomains.items), level, time.Since(start).Seconds())
+
+		pending = nil
+		for _, dmn := range levelDomains.items {
+			for _, spec := range dmn.spec.Subdomains {
+				pending = append(pending, pendingDomain{dmn.id, spec})
+			}
+		}
+		domains = append(domains, levelDomains.items...)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	start := time.Now()
+	var accounts created[topologyAccount]
+	workerPool := newPool()
+accounts:
+	for _, dmn := range domains {
+		dmn := dmn
+		for _, spec := range dmn.spec.Accounts {
+			spec := spec
+			accountType := domain.AccountTypeDomainAdmin
+			if spec.Type == topology.AccountTypeUser {
+				accountType = domain.AccountTypeUser
+			}
+			for i := 0; i < spec.Count; i++ {
+				submitted := workerPool.Go("account", func(cs *cloudstack.CloudStackClient) *Result {
+					taskStart := time.Now()
+					account, err := domain.CreateAccount(cs, b.cfg, dmn.id, accountType)
+					if err == nil {
+						inv.add(ResourceAccount, account.Id, account.Name, dmn.id)
+						accounts.add(topologyAccount{account.Name, dmn.id, spec})
+					}
+					return newResult(taskStart, "createAccount", err)
+				})
+				if !submitted {
+					break accounts
+				}
+			}
+		}
+	}
+	results["account"] = workerPool.Wait()
+	log.Infof("Created %d accounts in %.2f seconds", len(accounts.items), time.Since(start).Seconds())
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	start = time.Now()
+	workerPool = newPool()
+users:
+	for _, account := range accounts.items {
+		account := account
+		for i := 1; i < account.spec.Users; i++ {
+			submitted := workerPool.Go("user", func(cs *cloudstack.CloudStackClient) *Result {
+				taskStart := time.Now()
+				_, err := domain.CreateUser(cs, b.cfg, account.domainId, account.name)
+				return newResult(taskStart, "createUser", err)
+			})
+			if !submitted {
+				break users
+			}
+		}
+	}
+	if userResults := workerPool.Wait(); len(userResults) > 0 {
+		results["user"] = userResults
+		log.Infof("Created %d users in %.2f seconds", len(userResults), time.Since(start).Seconds())
+	}
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	start = time.Now()
+	var networks created[topologyNetwork]
+	workerPool = newPool()
+	count := 0
+networks:
+	for _, account := range accounts.items {
+		account := account
+		for _, spec := range account.spec.Networks {
+			spec := spec
+			for i := 0; i < spec.Count; i++ {
+				zoneId := b.cfg.ZoneIds[count%len(b.cfg.ZoneIds)]
+				count++
+				subnet, err := allocators[zoneId].Next()
+				if err != nil {
+					log.Warnf("Skipping a network of account %s in zone %s: %s", account.name, zoneId, err)
+					continue
+				}
+				submitted := workerPool.Go("network", func(cs *cloudstack.CloudStackClient) *Result {
+					taskStart := time.Now()
+					resp, err := network.CreateNetwork(cs, b.cfg, zoneId, account.domainId, account.name, subnet)
+					if err != nil {
+						return newResult(taskStart, "createNetwork", err)
+					}
+					inv.add(ResourceNetwork, resp.Id, resp.Name, account.domainId)
+					networks.add(topologyNetwork{resp.Id, zoneId, account.domainId, account.name, spec})
+					err = tags.CreateTags(cs, b.cfg, tags.ResourceTypeNetwork, resp.Id)
+					return newResult(taskStart, "createTags", err)
+				})
+				if !submitted {
+					break networks
+				}
+			}
+		}
+	}
+	if networkResults := workerPool.Wait(); len(networkResults) > 0 {
+		results["network"] = networkResults
+		log.Infof("Created %d networks in %.2f seconds", len(networks.items), time.Since(start).Seconds())
+	}
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	start = time.Now()
+	var vms created[topologyVm]
+	workerPool = newPool()
+vms:
+	for _, n := range networks.items {
+		n := n
+		for _, group := range n.spec.Vms {
+			group := group
+			for i := 0; i < group.Count; i++ {
+				submitted := workerPool.Go("vm", func(cs *cloudstack.CloudStackClient) *Result {
+					taskStart := time.Now()
+					resp, err := vm.DeployVmWithOffering(cs, b.cfg, serviceOfferings[group.ServiceOffering], n.zoneId, n.domainId, n.id, n.account)
+					if err != nil {
+						return newResult(taskStart, "deployVirtualMachine", err)
+					}
+					inv.add(ResourceVm, resp.Id, resp.Name, n.domainId)
+					vms.add(topologyVm{resp.Id, n.zoneId, n.domainId, n.account, group})
+					err = tags.CreateTags(cs, b.cfg, tags.ResourceTypeVm, resp.Id)
+					return newResult(taskStart, "createTags", err)
+				})
+				if !submitted {
+					break vms
+				}
+			}
+		}
+	}
+	if vmResults := workerPool.Wait(); len(vmResults) > 0 {
+		results["vm"] = vmResults
+		log.Infof("Created %d VMs in %.2f seconds", len(vms.items), time.Since(start).Seconds())
+	}
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	start = time.Now()
+	workerPool = newPool()
+volumes:
+	for _, v := range vms.items {
+		v := v
+		for i := 0; i < v.group.Volumes; i++ {
+			submitted := workerPool.Go("volume", func(cs *cloudstack.CloudStackClient) *Result {
+				taskStart := time.Now()
+				vol, err := volume.CreateVolumeWithOffering(cs, b.cfg, diskOfferings[v.group.DiskOffering], v.zoneId, v.domainId, v.account)
+				if err != nil {
+					return newResult(taskStart, "createVolume", err)
+				}
+				inv.add(ResourceVolume, vol.Id, vol.Name, v.domainId)
+				if err := tags.CreateTags(cs, b.cfg, tags.ResourceTypeVolume, vol.Id); err != nil {
+					return newResult(taskStart, "createTags", err)
+				}
+				_, err = volume.AttachVolume(cs, vol.Id, v.id)
+				return newResult(taskStart, "attachVolume", err)
+			})
+			if !submitted {
+				break volumes
+			}
+		}
+	}
+	if volumeResults := workerPool.Wait(); len(volumeResults) > 0 {
+		results["volume"] = volumeResults
+		log.Infof("Created %d volumes in %.2f seconds", len(volumeResults), time.Since(start).Seconds())
+	}
+	return results, ctx.Err()
+}
+
+// resolveOfferings returns the IDs of the named offerings, keyed by name, with
+// the empty name mapped to the default offering ID.
+func resolveOfferings(cs *cloudstack.CloudStackClient, names []string, defaultId string, find func(cs *cloudstack.CloudStackClient, id string, name string) (string, error)) (map[string]string, error) {
+	ids := map[string]string{"": defaultId}
+	for _, name := range names {
+		id, err := find(cs, "", name)
+		if err != nil {
+			return nil, err
+		}
+		ids[name] = id
+	}
+	return ids, nil
+}
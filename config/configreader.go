@@ -19,184 +19,566 @@ package config
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
+/*
+The configuration file consists of global settings followed by one section per
+profile, e.g. [admin], holding the credentials of that profile. Every setting
+is a "key = value" line, and lines starting with ";" are comments. The keys
+are the ini tags of the Config and Profile fields, and the default tags hold
+the values used when a key is not set.
+
+The values of the settings tagged as secret can also reference where to read
+the secret from, instead of holding it in plain text:
+  env:NAME    the value of the NAME environment variable
+  file:PATH   the content of the file at PATH
+  cmd:COMMAND the output of COMMAND, run with sh -c
+*/
+
 type Profile struct {
 	Name             string
-	ApiKey           string
-	SecretKey        string
-	Expires          int `default:"600"`
-	SignatureVersion int `default:"3"`
-	Timeout          int `default:"3600"`
-}
-
-var URL = "http://localhost:8080/client/api/"
-var Iterations = 1
-var Page = 0
-var PageSize = 0
-var Host = ""
-var ZoneId = ""
-var NetworkOfferingId = ""
-var ServiceOfferingId = ""
-var DiskOfferingId = ""
-var TemplateId = ""
-var ParentDomainId = ""
-var NumDomains = 0
-var NumVms = 0
-var NumVolumes = 0
-
-func ReadProfiles(filePath string) (map[int]*Profile, error) {
+	ApiKey           string `ini:"apikey" secret:"true"`
+	SecretKey        string `ini:"secretkey" secret:"true"`
+	Expires          int    `ini:"expires" default:"600"`
+	SignatureVersion int    `ini:"signatureversion" default:"3"`
+	Timeout          int    `ini:"timeout" default:"3600"`
+}
+
+type Config struct {
+	URL                string     `ini:"url" default:"http://localhost:8080/client/api/"`
+	Endpoints          Endpoints  `ini:"endpoints"`
+	EndpointMode       string     `ini:"endpointmode" default:"url"`
+	Iterations         int        `ini:"iterations" default:"1"`
+	Page               int        `ini:"page" default:"0"`
+	PageSize           int        `ini:"pagesize" default:"0"`
+	ZoneIds            []string   `ini:"zoneid"`
+	NetworkOfferingId  string     `ini:"networkofferingid"`
+	ServiceOfferingId  string     `ini:"serviceofferingid"`
+	DiskOfferingId     string     `ini:"diskofferingid"`
+	TemplateId         string     `ini:"templateid"`
+	VpcOfferingId      string     `ini:"vpcofferingid"`
+	VpcTierOfferingId  string     `ini:"vpctierofferingid"`
+	IsolatedOfferingId string     `ini:"isolatedofferingid"`
+	Zones              []string   `ini:"zone"`
+	NetworkOffering    string     `ini:"networkoffering"`
+	ServiceOffering    string     `ini:"serviceoffering"`
+	DiskOffering       string     `ini:"diskoffering"`
+	Template           string     `ini:"template"`
+	VpcOffering        string     `ini:"vpcoffering"`
+	VpcTierOffering    string     `ini:"vpctieroffering"`
+	IsolatedOffering   string     `ini:"isolatedoffering"`
+	ParentDomainId     string     `ini:"parentdomainid"`
+	NumDomains         int        `ini:"numdomains" default:"0"`
+	DomainDepth        int        `ini:"domaindepth" default:"1"`
+	DomainFanout       int        `ini:"domainfanout" default:"2"`
+	NumVms             int        `ini:"numvms" default:"0"`
+	NumVolumes         int        `ini:"numvolumes" default:"0"`
+	NumVpcs            int        `ini:"numvpcs" default:"1"`
+	NumTiers           int        `ini:"numtiers" default:"2"`
+	VpcCidr            string     `ini:"vpccidr" default:"10.0.0.0/16"`
+	NumIsolated        int        `ini:"numisolated" default:"1"`
+	NumPublicIps       int        `ini:"numpublicips" default:"1"`
+	NumFirewallRules   int        `ini:"numfirewallrules" default:"1"`
+	NumEgressRules     int        `ini:"numegressrules" default:"1"`
+	NumPfRules         int        `ini:"numportforwardingrules" default:"1"`
+	NumLbRules         int        `ini:"numlbrules" default:"1"`
+	NumSnapshots       int        `ini:"numsnapshots" default:"1"`
+	NumVmSnapshots     int        `ini:"numvmsnapshots" default:"1"`
+	SnapshotPolicy     string     `ini:"snapshotpolicy"`
+	NumTemplates       int        `ini:"numtemplates" default:"2"`
+	NumIsos            int        `ini:"numisos" default:"1"`
+	TemplateUrl        string     `ini:"templateurl"`
+	IsoUrl             string     `ini:"isourl"`
+	ImageVisibility    []string   `ini:"imagevisibility" default:"private, public, featured"`
+	VlanPool           VlanRanges `ini:"vlanpool" default:"80-4094"`
+	ReservedVlans      VlanRanges `ini:"reservedvlans"`
+	CidrPool           string     `ini:"cidrpool" default:"10.64.0.0/10"`
+	SubnetPrefix       int        `ini:"subnetprefix" default:"22"`
+	NamePrefix         string     `ini:"nameprefix" default:"csbench"`
+	Profiles           []*Profile
+
+	// RunId identifies the run which creates resources, in their names and
+	// tags. It is not read from the file but set for every run.
+	RunId string
+}
+
+// Endpoint modes select which management server endpoints are used.
+const (
+	// EndpointModeURL uses only the url endpoint.
+	EndpointModeURL = "url"
+	// EndpointModeEach benchmarks the url and each of the endpoints in turn.
+	EndpointModeEach = "each"
+	// EndpointModeDistribute distributes the requests across the endpoints.
+	EndpointModeDistribute = "distribute"
+)
+
+// Endpoint is the API URL of a management server, or of a load balancer in front of them.
+type Endpoint struct {
+	Name string
+	URL  string
+}
+
+// Endpoints is a list of endpoints, set in the configuration as a comma
+// separated list of "name=url" entries. The name defaults to the hostname.
+type Endpoints []Endpoint
+
+func (e *Endpoints) Set(value string) error {
+	*e = nil
+	for _, entry := range splitList(value) {
+		endpoint := Endpoint{URL: entry}
+		if name, endpointURL, ok := strings.Cut(entry, "="); ok && !strings.Contains(name, "://") {
+			endpoint = Endpoint{Name: strings.TrimSpace(name), URL: strings.TrimSpace(endpointURL)}
+		}
+		if endpoint.Name == "" {
+			parsedURL, err := url.Parse(endpoint.URL)
+			if err != nil {
+				return fmt.Errorf("error parsing url %s: %w", endpoint.URL, err)
+			}
+			endpoint.Name = parsedURL.Hostname()
+		}
+		*e = append(*e, endpoint)
+	}
+	return nil
+}
+
+// valueSetter is implemented by the settings which are parsed from a custom format.
+type valueSetter interface {
+	Set(value string) error
+}
+
+// New returns a configuration with all the defaults applied and no profiles.
+func New() *Config {
+	cfg := &Config{}
+	if err := setDefaults(cfg); err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// NewProfile returns a profile with the given name and all the defaults applied.
+func NewProfile(name string) *Profile {
+	profile := &Profile{Name: name}
+	if err := setDefaults(profile); err != nil {
+		panic(err)
+	}
+	return profile
+}
+
+// SetProfile replaces the profile with the same name, or adds it if there is none.
+func (c *Config) SetProfile(profile *Profile) {
+	for i, p := range c.Profiles {
+		if p.Name == profile.Name {
+			c.Profiles[i] = profile
+			return
+		}
+	}
+	c.Profiles = append(c.Profiles, profile)
+}
+
+// DomainTreeSize returns the number of domains created by the domain stage:
+// numdomains children of the parent domain, each with domainfanout children
+// down to domaindepth levels.
+func (c *Config) DomainTreeSize() int {
+	total, level := 0, c.NumDomains
+	for depth := 1; depth <= c.DomainDepth; depth++ {
+		total += level
+		level *= c.DomainFanout
+	}
+	return total
+}
+
+// Host returns the hostname of the management server.
+func (c *Config) Host() string {
+	parsedURL, err := url.Parse(c.URL)
+	if err != nil {
+		return ""
+	}
+	return parsedURL.Hostname()
+}
+
+/*
+ActiveEndpoints returns the endpoints used for the configured endpoint mode:
+the url in the url mode, the url followed by the endpoints in the each mode,
+and the endpoints in the distribute mode.
+*/
+func (c *Config) ActiveEndpoints() []Endpoint {
+	primary := Endpoint{Name: c.Host(), URL: c.URL}
+	switch c.EndpointMode {
+	case EndpointModeEach:
+		return append([]Endpoint{primary}, c.Endpoints...)
+	case EndpointModeDistribute:
+		return append([]Endpoint(nil), c.Endpoints...)
+	default:
+		return []Endpoint{primary}
+	}
+}
+
+// Profile returns the profile with the given name, or nil if there is none.
+func (c *Config) Profile(name string) *Profile {
+	for _, profile := range c.Profiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return nil
+}
+
+/*
+ReadConfig reads and validates the configuration file. All the problems found
+in the file are reported in the returned error, each prefixed with the file
+name and line number. Profiles without an API key or secret key are skipped
+with a warning.
+*/
+func ReadConfig(filePath string) (*Config, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file: %w", err)
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	profiles := make(map[int]*Profile)
-	var currentProfile string
+	cfg := New()
+	var profile *Profile
+	var errs []error
+	seen := make(map[string]bool)
+	fail := func(lineNumber int, format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s:%d: %s", filePath, lineNumber, fmt.Sprintf(format, a...)))
+	}
 
-	i := 0
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
 	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
 
 		if line == "" || strings.HasPrefix(line, ";") {
 			continue
 		}
 
 		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			currentProfile = line[1 : len(line)-1]
-			i++
-			profiles[i] = &Profile{}
-			profiles[i].Name = currentProfile
-		} else {
-			// Parse key-value pairs within the profile
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-
-				switch strings.ToLower(key) {
-				case "apikey":
-					profiles[i].ApiKey = value
-				case "secretkey":
-					profiles[i].SecretKey = value
-				case "url":
-					URL = value
-				case "iterations":
-					var iterations int
-					_, err := fmt.Sscanf(value, "%d", &iterations)
-					if err == nil {
-						Iterations = iterations
-					}
-				case "page":
-					var page int
-					_, err := fmt.Sscanf(value, "%d", &page)
-					if err == nil {
-						Page = page
-					}
-				case "pagesize":
-					var pagesize int
-					_, err := fmt.Sscanf(value, "%d", &pagesize)
-					if err == nil {
-						PageSize = pagesize
-					}
-				case "expires":
-					var expires int
-					_, err := fmt.Sscanf(value, "%d", &expires)
-					if err == nil {
-						profiles[i].Expires = expires
-					}
-				case "signatureversion":
-					var signatureVersion int
-					_, err := fmt.Sscanf(value, "%d", &signatureVersion)
-					if err == nil {
-						profiles[i].SignatureVersion = signatureVersion
-					}
-				case "timeout":
-					var timeout int
-					_, err := fmt.Sscanf(value, "%d", &timeout)
-					if err == nil {
-						profiles[i].Timeout = timeout
-					}
-				case "zoneid":
-					ZoneId = value
-				case "networkofferingid":
-					NetworkOfferingId = value
-				case "serviceofferingid":
-					ServiceOfferingId = value
-				case "diskofferingid":
-					DiskOfferingId = value
-				case "templateid":
-					TemplateId = value
-				case "parentdomainid":
-					ParentDomainId = value
-				case "numdomains":
-					var numDomains int
-					_, err := fmt.Sscanf(value, "%d", &numDomains)
-					if err == nil {
-						NumDomains = numDomains
-					}
-				case "numvms":
-					var numVms int
-					_, err := fmt.Sscanf(value, "%d", &numVms)
-					if err == nil {
-						NumVms = numVms
-					}
-				case "numvolumes":
-					var numVolumes int
-					_, err := fmt.Sscanf(value, "%d", &numVolumes)
-					if err == nil {
-						NumVolumes = numVolumes
-					}
-				}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				fail(lineNumber, "empty profile name")
+			} else if cfg.Profile(name) != nil {
+				fail(lineNumber, "duplicate profile [%s]", name)
+			}
+			profile = NewProfile(name)
+			cfg.Profiles = append(cfg.Profiles, profile)
+			seen = make(map[string]bool)
+			continue
+		}
+
+		// Parse key-value pairs
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			fail(lineNumber, "expected \"key = value\" or \"[profile]\", got %q", line)
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		if seen[key] {
+			fail(lineNumber, "duplicate key %q", key)
+			continue
+		}
+		seen[key] = true
+
+		var target interface{} = cfg
+		if profile != nil {
+			target = profile
+		}
+		found, err := setField(target, key, value)
+		if err != nil {
+			fail(lineNumber, "%s", err)
+			continue
+		}
+		if found {
+			continue
+		}
+
+		if profile != nil {
+			if ok, _ := hasField(cfg, key); ok {
+				fail(lineNumber, "%q is a global setting and must be set before the first profile", key)
+				continue
 			}
+		} else if ok, _ := hasField(&Profile{}, key); ok {
+			fail(lineNumber, "%q is a profile setting and must be set within a profile", key)
+			continue
 		}
+		fail(lineNumber, "unknown key %q", key)
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("error scanning file: %w", err)
 	}
 
-	if len(profiles) == 0 {
-		fmt.Println("No roles are defined in the configuration file")
-		return nil, fmt.Errorf("no roles are defined in the configuration file: %w", err)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
-	if URL == "" {
-		log.Fatalln("URL not found in the configuration, please verify")
+	skipIncompleteProfiles(cfg)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
 	}
 
-	parsedURL, err := url.Parse(URL)
-	if err != nil {
-		log.Errorf("Error parsing URL : %s with error : %s", URL, err)
-		return nil, fmt.Errorf("error parsing url : %s with error : %s", URL, err)
+	return cfg, nil
+}
+
+// Validate checks that the settings of the configuration are consistent.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.Profiles) == 0 {
+		errs = append(errs, fmt.Errorf("no roles are defined in the configuration"))
 	}
-	Host = parsedURL.Hostname()
 
-	validateConfig(profiles)
+	if c.URL == "" {
+		errs = append(errs, fmt.Errorf("url is not set"))
+	} else if parsedURL, err := url.Parse(c.URL); err != nil {
+		errs = append(errs, fmt.Errorf("error parsing url %s: %w", c.URL, err))
+	} else if parsedURL.Scheme == "" || parsedURL.Hostname() == "" {
+		errs = append(errs, fmt.Errorf("url %s must include the scheme and host", c.URL))
+	}
 
-	return profiles, nil
-}
+	switch c.EndpointMode {
+	case EndpointModeURL:
+	case EndpointModeEach, EndpointModeDistribute:
+		if len(c.Endpoints) == 0 {
+			errs = append(errs, fmt.Errorf("endpoints must be set when endpointmode is %s", c.EndpointMode))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("endpointmode must be one of %s, %s, %s, got %q", EndpointModeURL, EndpointModeEach, EndpointModeDistribute, c.EndpointMode))
+	}
+	endpointNames := make(map[string]bool)
+	if c.EndpointMode == EndpointModeEach {
+		endpointNames[c.Host()] = true
+	}
+	for _, endpoint := range c.Endpoints {
+		if parsedURL, err := url.Parse(endpoint.URL); err != nil || parsedURL.Scheme == "" || parsedURL.Hostname() == "" {
+			errs = append(errs, fmt.Errorf("endpoint %s must be a url including the scheme and host, got %q", endpoint.Name, endpoint.URL))
+		}
+		if endpointNames[endpoint.Name] {
+			errs = append(errs, fmt.Errorf("duplicate endpoint name %s", endpoint.Name))
+		}
+		endpointNames[endpoint.Name] = true
+	}
 
-func validateConfig(profiles map[int]*Profile) bool {
+	if c.Iterations < 1 {
+		errs = append(errs, fmt.Errorf("iterations must be at least 1, got %d", c.Iterations))
+	}
+	if c.Page < 0 {
+		errs = append(errs, fmt.Errorf("page must not be negative, got %d", c.Page))
+	}
+	if c.PageSize < 0 {
+		errs = append(errs, fmt.Errorf("pagesize must not be negative, got %d", c.PageSize))
+	}
+	if c.Page > 0 && c.PageSize == 0 {
+		errs = append(errs, fmt.Errorf("pagesize must be set when page is set"))
+	}
+	byName := []struct {
+		idKey, nameKey string
+		id, name       bool
+	}{
+		{"zoneid", "zone", len(c.ZoneIds) > 0, len(c.Zones) > 0},
+		{"networkofferingid", "networkoffering", c.NetworkOfferingId != "", c.NetworkOffering != ""},
+		{"serviceofferingid", "serviceoffering", c.ServiceOfferingId != "", c.ServiceOffering != ""},
+		{"diskofferingid", "diskoffering", c.DiskOfferingId != "", c.DiskOffering != ""},
+		{"templateid", "template", c.TemplateId != "", c.Template != ""},
+		{"vpcofferingid", "vpcoffering", c.VpcOfferingId != "", c.VpcOffering != ""},
+		{"vpctierofferingid", "vpctieroffering", c.VpcTierOfferingId != "", c.VpcTierOffering != ""},
+		{"isolatedofferingid", "isolatedoffering", c.IsolatedOfferingId != "", c.IsolatedOffering != ""},
+	}
+	for _, setting := range byName {
+		if setting.id && setting.name {
+			errs = append(errs, fmt.Errorf("only one of %s and %s can be set", setting.idKey, setting.nameKey))
+		}
+	}
+	counts := []struct {
+		key   string
+		value int
+	}{{"numdomains", c.NumDomains}, {"numvms", c.NumVms}, {"numvolumes", c.NumVolumes}, {"domainfanout", c.DomainFanout},
+		{"numvpcs", c.NumVpcs}, {"numtiers", c.NumTiers}, {"numisolated", c.NumIsolated}, {"numpublicips", c.NumPublicIps},
+		{"numfirewallrules", c.NumFirewallRules}, {"numegressrules", c.NumEgressRules}, {"numportforwardingrules", c.NumPfRules}, {"numlbrules", c.NumLbRules},
+		{"numsnapshots", c.NumSnapshots}, {"numvmsnapshots", c.NumVmSnapshots}, {"numtemplates", c.NumTemplates}, {"numisos", c.NumIsos}}
+	for _, count := range counts {
+		if count.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %d", count.key, count.value))
+		}
+	}
 
-	result := true
-	for i, profile := range profiles {
+	if _, cidr, err := net.ParseCIDR(c.VpcCidr); err != nil || cidr.IP.To4() == nil {
+		errs = append(errs, fmt.Errorf("vpccidr must be an IPv4 CIDR, got %q", c.VpcCidr))
+	} else if ones, _ := cidr.Mask.Size(); ones > 24 {
+		errs = append(errs, fmt.Errorf("vpccidr must be at most a /24 to hold the /24 tiers, got %s", c.VpcCidr))
+	} else if c.NumTiers > 1<<(24-ones) {
+		errs = append(errs, fmt.Errorf("numtiers must be at most %d, the /24 tiers which fit in vpccidr %s, got %d", 1<<(24-ones), c.VpcCidr, c.NumTiers))
+	}
+
+	switch c.SnapshotPolicy {
+	case "", "hourly", "daily", "weekly", "monthly":
+	default:
+		errs = append(errs, fmt.Errorf("snapshotpolicy must be one of hourly, daily, weekly, monthly or empty, got %q", c.SnapshotPolicy))
+	}
+
+	if len(c.ImageVisibility) == 0 {
+		errs = append(errs, fmt.Errorf("imagevisibility must not be empty"))
+	}
+	for _, visibility := range c.ImageVisibility {
+		switch visibility {
+		case "private", "public", "featured":
+		default:
+			errs = append(errs, fmt.Errorf("imagevisibility must list private, public or featured, got %q", visibility))
+		}
+	}
+
+	if len(c.VlanPool) == 0 {
+		errs = append(errs, fmt.Errorf("vlanpool must not be empty"))
+	}
+	if _, cidr, err := net.ParseCIDR(c.CidrPool); err != nil || cidr.IP.To4() == nil {
+		errs = append(errs, fmt.Errorf("cidrpool must be an IPv4 CIDR, got %q", c.CidrPool))
+	} else if ones, _ := cidr.Mask.Size(); c.SubnetPrefix < ones || c.SubnetPrefix > 29 {
+		errs = append(errs, fmt.Errorf("subnetprefix must be between %d, the prefix of cidrpool %s, and 29, got %d", ones, c.CidrPool, c.SubnetPrefix))
+	}
+
+	if !namePrefixRegex.MatchString(c.NamePrefix) {
+		errs = append(errs, fmt.Errorf("nameprefix must start with a letter and be at most 20 letters, digits and hyphens, got %q", c.NamePrefix))
+	}
+
+	if c.DomainDepth < 1 {
+		errs = append(errs, fmt.Errorf("domaindepth must be at least 1, got %d", c.DomainDepth))
+	}
+	if c.DomainDepth > 1 && c.DomainFanout < 1 {
+		errs = append(errs, fmt.Errorf("domainfanout must be at least 1 when domaindepth is more than 1, got %d", c.DomainFanout))
+	}
+
+	for _, profile := range c.Profiles {
+		if profile.Expires <= 0 {
+			errs = append(errs, fmt.Errorf("[%s] expires must be positive, got %d", profile.Name, profile.Expires))
+		}
+		if profile.SignatureVersion <= 0 {
+			errs = append(errs, fmt.Errorf("[%s] signatureversion must be positive, got %d", profile.Name, profile.SignatureVersion))
+		}
+		if profile.Timeout <= 0 {
+			errs = append(errs, fmt.Errorf("[%s] timeout must be positive, got %d", profile.Name, profile.Timeout))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func skipIncompleteProfiles(cfg *Config) {
+	profiles := cfg.Profiles[:0]
+	for _, profile := range cfg.Profiles {
 		if profile.ApiKey == "" || profile.SecretKey == "" {
 			message := "Please check ApiKey, SecretKey of the profile. They should not be empty"
-			fmt.Printf("Skipping profile [%s] : %s\n", profile.Name, message)
-			delete(profiles, i)
-			result = false
+			log.Warnf("Skipping profile [%s] : %s", profile.Name, message)
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+	cfg.Profiles = profiles
+}
+
+// setDefaults sets every field of the struct pointed to by v to the value of its default tag.
+func setDefaults(v interface{}) error {
+	elem := reflect.ValueOf(v).Elem()
+	for i := 0; i < elem.NumField(); i++ {
+		value, ok := elem.Type().Field(i).Tag.Lookup("default")
+		if !ok {
+			continue
+		}
+		if err := setValue(elem.Field(i), value); err != nil {
+			return fmt.Errorf("invalid default for %s: %w", elem.Type().Field(i).Name, err)
+		}
+	}
+	return nil
+}
+
+// hasField reports whether the struct pointed to by v has a field with the given ini key.
+func hasField(v interface{}, key string) (bool, reflect.Value) {
+	ok, field, _ := findField(v, key)
+	return ok, field
+}
+
+func findField(v interface{}, key string) (bool, reflect.Value, reflect.StructField) {
+	elem := reflect.ValueOf(v).Elem()
+	for i := 0; i < elem.NumField(); i++ {
+		if elem.Type().Field(i).Tag.Get("ini") == key {
+			return true, elem.Field(i), elem.Type().Field(i)
 		}
 	}
+	return false, reflect.Value{}, reflect.StructField{}
+}
 
-	return result
+// setField sets the field with the given ini key of the struct pointed to by v,
+// and reports whether there is such a field. The references of secret fields
+// are resolved first.
+func setField(v interface{}, key string, value string) (bool, error) {
+	ok, field, structField := findField(v, key)
+	if !ok {
+		return false, nil
+	}
+	if structField.Tag.Get("secret") == "true" {
+		secret, err := ResolveSecret(value)
+		if err != nil {
+			return true, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		field.SetString(secret)
+		return true, nil
+	}
+	if err := setValue(field, value); err != nil {
+		return true, fmt.Errorf("invalid value %q for %s: %w", value, key, err)
+	}
+	return true, nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	if setter, ok := field.Addr().Interface().(valueSetter); ok {
+		return setter.Set(value)
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported setting type %s", field.Type())
+		}
+		field.Set(reflect.ValueOf(splitList(value)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Kind())
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, ignoring the empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
 }
@@ -0,0 +1,200 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes the lines to a configuration file and returns its path.
+func writeConfig(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{
+			name: "bad integer",
+			lines: []string{
+				"url = http://cs:8080/client/api/",
+				"iterations = many",
+				"[admin]",
+				"apikey = key",
+				"secretkey = secret",
+				"timeout = 1h",
+			},
+			want: []string{
+				`:2: invalid value "many" for iterations: must be an integer`,
+				`:6: invalid value "1h" for timeout: must be an integer`,
+			},
+		},
+		{
+			name: "unknown key",
+			lines: []string{
+				"; a comment",
+				"url = http://cs:8080/client/api/",
+				"numvm = 10",
+				"[admin]",
+				"apikey = key",
+				"secretkey = secret",
+				"colour = blue",
+			},
+			want: []string{
+				`:3: unknown key "numvm"`,
+				`:7: unknown key "colour"`,
+			},
+		},
+		{
+			name: "misplaced keys",
+			lines: []string{
+				"apikey = key",
+				"[admin]",
+				"secretkey = secret",
+				"iterations = 2",
+			},
+			want: []string{
+				`:1: "apikey" is a profile setting and must be set within a profile`,
+				`:4: "iterations" is a global setting and must be set before the first profile`,
+			},
+		},
+		{
+			name: "duplicates and syntax",
+			lines: []string{
+				"page = 1",
+				"page = 2",
+				"[admin]",
+				"apikey = key",
+				"[admin]",
+				"secretkey",
+			},
+			want: []string{
+				`:2: duplicate key "page"`,
+				`:5: duplicate profile [admin]`,
+				`:6: expected "key = value" or "[profile]", got "secretkey"`,
+			},
+		},
+		{
+			name: "missing profile keys",
+			lines: []string{
+				"[admin]",
+				"apikey = key",
+				"[user]",
+				"secretkey = secret",
+			},
+			want: []string{
+				"no roles are defined in the configuration",
+			},
+		},
+		{
+			name: "inconsistent settings",
+			lines: []string{
+				"page = 1",
+				"zoneid = 1",
+				"zone = zone1",
+				"[admin]",
+				"apikey = key",
+				"secretkey = secret",
+				"expires = 0",
+			},
+			want: []string{
+				"pagesize must be set when page is set",
+				"only one of zoneid and zone can be set",
+				"[admin] expires must be positive, got 0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.lines...)
+			cfg, err := ReadConfig(path)
+			if err == nil {
+				t.Fatalf("ReadConfig returned %+v, want an error", cfg)
+			}
+			for _, want := range tt.want {
+				if strings.HasPrefix(want, ":") {
+					want = path + want
+				}
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadConfigDefaults(t *testing.T) {
+	path := writeConfig(t,
+		"url = http://cs:8080/client/api/",
+		"numvms = 3",
+		"[admin]",
+		"apikey = key",
+		"secretkey = secret",
+		"timeout = 60",
+		"[user]",
+		"apikey = key",
+	)
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.NumVms != 3 {
+		t.Errorf("NumVms = %d, want 3", cfg.NumVms)
+	}
+	defaults := []struct {
+		key  string
+		got  interface{}
+		want interface{}
+	}{
+		{"endpointmode", cfg.EndpointMode, EndpointModeURL},
+		{"iterations", cfg.Iterations, 1},
+		{"domaindepth", cfg.DomainDepth, 1},
+		{"domainfanout", cfg.DomainFanout, 2},
+		{"vpccidr", cfg.VpcCidr, "10.0.0.0/16"},
+		{"imagevisibility", cfg.ImageVisibility, []string{"private", "public", "featured"}},
+		{"vlanpool", cfg.VlanPool, VlanRanges{{80, 4094}}},
+		{"nameprefix", cfg.NamePrefix, "csbench"},
+	}
+	for _, d := range defaults {
+		if !reflect.DeepEqual(d.got, d.want) {
+			t.Errorf("%s = %v, want the default %v", d.key, d.got, d.want)
+		}
+	}
+
+	// The user profile without a secret key is skipped.
+	if len(cfg.Profiles) != 1 || cfg.Profiles[0].Name != "admin" {
+		t.Fatalf("Profiles = %+v, want only admin", cfg.Profiles)
+	}
+	admin := cfg.Profiles[0]
+	if admin.Expires != 600 || admin.SignatureVersion != 3 || admin.Timeout != 60 {
+		t.Errorf("admin profile = %+v, want expires 600, signatureversion 3 and timeout 60", admin)
+	}
+}
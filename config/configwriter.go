@@ -0,0 +1,160 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"csbench/utils"
+)
+
+/*
+UpdateFile writes the configuration file at path to out with the given global
+settings and profiles set. The settings and the sections of the profiles
+already in the file are replaced in place, the other settings are added at the
+end of the global settings and the other profiles are appended, while the rest
+of the file, including the comments and the secret references of the other
+profiles, is kept as is. The settings with an empty value are removed. The
+file is written to a temporary file first, so path and out can be the same.
+*/
+func UpdateFile(path string, out string, settings map[string]string, profiles []*Profile) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	pendingSettings := make(map[string]string)
+	for key, value := range settings {
+		pendingSettings[key] = value
+	}
+	pendingProfiles := make(map[string]*Profile)
+	for _, profile := range profiles {
+		pendingProfiles[profile.Name] = profile
+	}
+
+	var lines []string
+	inGlobal := true
+	replacing := false
+	// globalEnd is the index after the last global setting, where the new settings are added.
+	globalEnd := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		isSetting := trimmed != "" && !strings.HasPrefix(trimmed, ";")
+		switch {
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			inGlobal = false
+			name := strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			profile, ok := pendingProfiles[name]
+			replacing = ok
+			if ok {
+				lines = append(lines, formatProfile(profile)...)
+				delete(pendingProfiles, name)
+				continue
+			}
+		case inGlobal && isSetting:
+			key := strings.ToLower(strings.TrimSpace(strings.SplitN(trimmed, "=", 2)[0]))
+			if value, ok := pendingSettings[key]; ok {
+				delete(pendingSettings, key)
+				if value == "" {
+					continue
+				}
+				line = fmt.Sprintf("%s = %s", key, value)
+			}
+			lines = append(lines, line)
+			globalEnd = len(lines)
+			continue
+		case replacing && isSetting:
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+
+	keys := make([]string, 0, len(pendingSettings))
+	for key, value := range pendingSettings {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	added := make([]string, 0, len(keys))
+	for _, key := range keys {
+		added = append(added, fmt.Sprintf("%s = %s", key, pendingSettings[key]))
+	}
+	lines = append(lines[:globalEnd], append(added, lines[globalEnd:]...)...)
+
+	for _, profile := range profiles {
+		if _, ok := pendingProfiles[profile.Name]; ok {
+			lines = append(lines, "")
+			lines = append(lines, formatProfile(profile)...)
+		}
+	}
+
+	// The file holds credentials, ReplaceFile leaves it readable only by its owner.
+	if err := utils.ReplaceFile(out, []byte(strings.Join(lines, "\n")+"\n")); err != nil {
+		return fmt.Errorf("error writing %s: %w", out, err)
+	}
+	return nil
+}
+
+// Settings returns the values of the given global settings as written in the
+// configuration file.
+func (c *Config) Settings(keys ...string) map[string]string {
+	settings := make(map[string]string)
+	for _, key := range keys {
+		if ok, field := hasField(c, key); ok {
+			settings[key] = formatValue(field)
+		}
+	}
+	return settings
+}
+
+// formatProfile returns the lines of the section of a profile.
+func formatProfile(profile *Profile) []string {
+	lines := []string{fmt.Sprintf("[%s]", profile.Name)}
+	elem := reflect.ValueOf(profile).Elem()
+	for i := 0; i < elem.NumField(); i++ {
+		key := elem.Type().Field(i).Tag.Get("ini")
+		if key == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s", key, formatValue(elem.Field(i))))
+	}
+	return lines
+}
+
+// formatValue returns the value of a field as written in the configuration file.
+func formatValue(field reflect.Value) string {
+	if stringer, ok := field.Interface().(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String {
+		return strings.Join(field.Interface().([]string), ", ")
+	}
+	return fmt.Sprint(field.Interface())
+}
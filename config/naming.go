@@ -0,0 +1,104 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"csbench/utils"
+)
+
+// The keys of the resource tags set on the resources created, where CloudStack supports them.
+const (
+	TagPrefix = "csbench-prefix"
+	TagRunId  = "csbench-run"
+)
+
+// The kinds of the resources named by ResourceName.
+const (
+	KindDomain       = "Domain"
+	KindAccount      = "Account"
+	KindUser         = "User"
+	KindNetwork      = "Network"
+	KindIsolated     = "Isolated"
+	KindVpc          = "Vpc"
+	KindAcl          = "Acl"
+	KindTier         = "Tier"
+	KindVm           = "Vm"
+	KindVolume       = "Volume"
+	KindLoadBalancer = "LB"
+	KindSnapshot     = "Snapshot"
+	KindVmSnapshot   = "VmSnapshot"
+	KindTemplate     = "Template"
+	KindIso          = "Iso"
+)
+
+// ResourceKinds lists every kind of resource named by ResourceName, so that
+// the generated names can be told apart in the error texts.
+var ResourceKinds = []string{KindDomain, KindAccount, KindUser, KindNetwork, KindIsolated, KindVpc, KindAcl, KindTier, KindVm, KindVolume, KindLoadBalancer, KindSnapshot, KindVmSnapshot, KindTemplate, KindIso}
+
+// The name prefix and run ID are part of the names of the VMs, which are
+// also their hostnames, so they are limited to the characters and length
+// allowed in a hostname along with the rest of the name.
+var (
+	namePrefixRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]{0,19}$`)
+	runIdRegex      = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]{0,23}$`)
+)
+
+// SetRunId sets the ID of the run, after checking it can be used in the names
+// of the resources.
+func (c *Config) SetRunId(runId string) error {
+	if !runIdRegex.MatchString(runId) {
+		return fmt.Errorf("run id must be at most 24 letters, digits and hyphens, got %q", runId)
+	}
+	c.RunId = runId
+	return nil
+}
+
+// ResourceName returns a unique name for a resource of the kind, e.g. Vm,
+// made of the name prefix, the run ID, the kind and a random suffix.
+func (c *Config) ResourceName(kind string) string {
+	name := kind + "-" + utils.RandomString(10)
+	if c.RunId != "" {
+		name = c.RunId + "-" + name
+	}
+	if c.NamePrefix != "" {
+		name = c.NamePrefix + "-" + name
+	}
+	return name
+}
+
+// IsResourceName reports whether the name was generated by ResourceName for a
+// resource of the kind, by any run with the name prefix of the configuration.
+func (c *Config) IsResourceName(name string, kind string) bool {
+	prefix := ""
+	if c.NamePrefix != "" {
+		prefix = regexp.QuoteMeta(c.NamePrefix + "-")
+	}
+	return regexp.MustCompile(`^` + prefix + `([a-zA-Z0-9-]+-)?` + regexp.QuoteMeta(kind) + `-[a-zA-Z]+$`).MatchString(name)
+}
+
+// ResourceTags returns the resource tags identifying the resources created by the run.
+func (c *Config) ResourceTags() map[string]string {
+	tags := map[string]string{TagPrefix: c.NamePrefix}
+	if c.RunId != "" {
+		tags[TagRunId] = c.RunId
+	}
+	return tags
+}
@@ -0,0 +1,75 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/*
+ResolveSecret returns the secret referenced by value, which is either
+"env:NAME", "file:PATH", "cmd:COMMAND", or the secret itself in plain text.
+The secrets read from a file or a command are trimmed of surrounding spaces
+and newlines.
+*/
+func ResolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimSpace(strings.TrimPrefix(value, "env:"))
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, "file:"):
+		path := strings.TrimSpace(strings.TrimPrefix(value, "file:"))
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(content)), nil
+	case strings.HasPrefix(value, "cmd:"):
+		command := strings.TrimSpace(strings.TrimPrefix(value, "cmd:"))
+		output, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+				return "", fmt.Errorf("command %q failed: %w: %s", command, err, strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			return "", fmt.Errorf("command %q failed: %w", command, err)
+		}
+		return strings.TrimSpace(string(output)), nil
+	default:
+		return value, nil
+	}
+}
+
+// Secrets returns the API and secret keys of all the profiles.
+func (c *Config) Secrets() []string {
+	var secrets []string
+	for _, profile := range c.Profiles {
+		for _, secret := range []string{profile.ApiKey, profile.SecretKey} {
+			if secret != "" {
+				secrets = append(secrets, secret)
+			}
+		}
+	}
+	return secrets
+}
@@ -0,0 +1,81 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxVlan is the highest VLAN ID.
+const MaxVlan = 4094
+
+// VlanRange is an inclusive range of VLAN IDs.
+type VlanRange struct {
+	Start int
+	End   int
+}
+
+// VlanRanges is a list of VLAN ranges, set in the configuration as a comma
+// separated list of "start-end" ranges and single VLAN IDs, e.g. "100-199, 250".
+type VlanRanges []VlanRange
+
+func (r *VlanRanges) Set(value string) error {
+	*r = nil
+	for _, entry := range splitList(value) {
+		first, last, isRange := strings.Cut(entry, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return fmt.Errorf("invalid VLAN %q", entry)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+				return fmt.Errorf("invalid VLAN range %q", entry)
+			}
+		}
+		if start < 1 || end > MaxVlan || start > end {
+			return fmt.Errorf("VLAN range %q must be within 1-%d", entry, MaxVlan)
+		}
+		*r = append(*r, VlanRange{Start: start, End: end})
+	}
+	return nil
+}
+
+func (r VlanRanges) String() string {
+	entries := make([]string, 0, len(r))
+	for _, vlanRange := range r {
+		if vlanRange.Start == vlanRange.End {
+			entries = append(entries, strconv.Itoa(vlanRange.Start))
+		} else {
+			entries = append(entries, fmt.Sprintf("%d-%d", vlanRange.Start, vlanRange.End))
+		}
+	}
+	return strings.Join(entries, ", ")
+}
+
+// Contains reports whether the VLAN is in one of the ranges.
+func (r VlanRanges) Contains(vlan int) bool {
+	for _, vlanRange := range r {
+		if vlan >= vlanRange.Start && vlan <= vlanRange.End {
+			return true
+		}
+	}
+	return false
+}
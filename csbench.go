@@ -18,40 +18,36 @@
 package main
 
 import (
-	"csbench/domain"
-	"csbench/network"
-	"csbench/vm"
-	"csbench/volume"
+	"context"
+	"csbench/bench"
+	"csbench/dashboard"
 	"flag"
 	"fmt"
 	"io"
-	"math"
+	stdlog "log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"csbench/apirunner"
 	"csbench/config"
+	"csbench/image"
+	"csbench/logger"
+	"csbench/topology"
 
-	log "github.com/sirupsen/logrus"
-
-	"github.com/apache/cloudstack-go/v2/cloudstack"
 	"github.com/jedib0t/go-pretty/v6/table"
-	"github.com/montanaflynn/stats"
-	"github.com/sourcegraph/conc/pool"
+	log "github.com/sirupsen/logrus"
 )
 
 var (
-	profiles = make(map[int]*config.Profile)
+	logFile *os.File
 )
 
-type Result struct {
-	Success  bool
-	Duration float64
-}
-
 func init() {
-	logFile, err := os.OpenFile("csmetrics.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	var err error
+	logFile, err = os.OpenFile("csmetrics.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		log.Fatalf("Failed to create log file: %v", err)
 	}
@@ -61,21 +57,61 @@ func init() {
 	log.SetOutput(mw)
 }
 
-func readConfigurations(configFile string) map[int]*config.Profile {
-	profiles, err := config.ReadProfiles(configFile)
-	if err != nil {
-		log.Fatal("Error reading profiles:", err)
+/*
+startDashboard starts the live terminal dashboard if it was requested and
+stdout is a terminal. While the dashboard is running the logs, including those
+the resource packages write with the standard log package, are written only to
+the log file so that they don't garble the display. The returned function stops
+the dashboard and restores logging to stdout and stderr.
+*/
+func startDashboard(enabled bool, title string) (*dashboard.Dashboard, func()) {
+	if !enabled {
+		return nil, func() {}
 	}
+	dash := dashboard.New(title)
+	if dash == nil {
+		log.Warn("stdout is not a terminal, falling back to plain logs")
+		return nil, func() {}
+	}
+	log.SetOutput(logFile)
+	stdlog.SetOutput(logFile)
+	dash.Start()
+	return dash, func() {
+		dash.Stop()
+		log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+		stdlog.SetOutput(os.Stderr)
+	}
+}
 
-	return profiles
+/*
+interruptibleContext returns a context that is cancelled on SIGINT or SIGTERM,
+so that the run stops issuing new requests and writes a partial report. A
+second signal terminates csbench immediately.
+*/
+func interruptibleContext(shutdownTimeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warnf("Received %s, waiting up to %s for in-flight requests to complete", sig, shutdownTimeout)
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(signals)
+		}
+	}()
+	return ctx, cancel
 }
 
-func logConfigurationDetails(profiles map[int]*config.Profile) {
-	apiURL := config.URL
-	iterations := config.Iterations
-	page := config.Page
-	pagesize := config.PageSize
-	host := config.Host
+func logConfigurationDetails(cfg *bench.Config) {
+	apiURL := cfg.URL
+	iterations := cfg.Iterations
+	page := cfg.Page
+	pagesize := cfg.PageSize
+	host := cfg.Host()
+	profiles := cfg.Profiles
 
 	userProfileNames := make([]string, 0, len(profiles))
 	for _, profile := range profiles {
@@ -84,6 +120,13 @@ func logConfigurationDetails(profiles map[int]*config.Profile) {
 
 	fmt.Printf("\n\n\033[1;34mBenchmarking the CloudStack environment [%s] with the following configuration\033[0m\n\n", apiURL)
 	fmt.Printf("Management server : %s\n", host)
+	if cfg.EndpointMode != config.EndpointModeURL {
+		endpointNames := make([]string, 0, len(cfg.Endpoints))
+		for _, endpoint := range cfg.ActiveEndpoints() {
+			endpointNames = append(endpointNames, endpoint.Name)
+		}
+		fmt.Printf("Endpoints (%s) : %s\n", cfg.EndpointMode, strings.Join(endpointNames, ","))
+	}
 	fmt.Printf("Roles : %s\n", strings.Join(userProfileNames, ","))
 	fmt.Printf("Iterations : %d\n", iterations)
 	fmt.Printf("Page : %d\n", page)
@@ -93,116 +136,115 @@ func logConfigurationDetails(profiles map[int]*config.Profile) {
 	log.Infof("Management server : %s", host)
 }
 
-func logReport() {
+func logReport(cfg *bench.Config, summary apirunner.Summary, interrupted bool) {
 	fmt.Printf("\n\n\nLog file : csmetrics.log\n")
-	fmt.Printf("Reports directory per API : report/%s/\n", config.Host)
-	fmt.Printf("Number of APIs : %d\n", apirunner.APIscount)
-	fmt.Printf("Successful APIs : %d\n", apirunner.SuccessAPIs)
-	fmt.Printf("Failed APIs : %d\n", apirunner.FailedAPIs)
-	fmt.Printf("Time in seconds per API: %.2f (avg)\n", apirunner.TotalTime/float64(apirunner.APIscount))
+	if interrupted {
+		fmt.Printf("\033[1;31mBenchmark interrupted, the results are partial\033[0m\n")
+	}
+	if cfg.EndpointMode == config.EndpointModeEach {
+		fmt.Printf("Reports directory per API : report/<endpoint host>/\n")
+	} else {
+		fmt.Printf("Reports directory per API : report/%s/\n", cfg.Host())
+	}
+	fmt.Printf("Number of APIs : %d\n", summary.APIsCount)
+	fmt.Printf("Successful APIs : %d\n", summary.SuccessAPIs)
+	fmt.Printf("Failed APIs : %d\n", summary.FailedAPIs)
+	fmt.Printf("Time in seconds per API: %.2f (avg)\n", summary.TotalTime/float64(summary.APIsCount))
+	if len(summary.Endpoints) > 1 {
+		fmt.Printf("\nPer endpoint:\n")
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Endpoint", "URL", "APIs", "Successful", "Failed", "Avg time (s)"})
+		for _, endpoint := range summary.Endpoints {
+			avg := 0.0
+			if endpoint.APIsCount > 0 {
+				avg = endpoint.TotalTime / float64(endpoint.APIsCount)
+			}
+			t.AppendRow(table.Row{endpoint.Name, endpoint.URL, endpoint.APIsCount, endpoint.SuccessAPIs, endpoint.FailedAPIs, fmt.Sprintf("%.2f", avg)})
+		}
+		t.Render()
+	}
+	if len(summary.Failures) > 0 {
+		fmt.Printf("\nError summary:\n")
+		t := bench.ErrorSummaryTable(summary.Failures)
+		t.SetOutputMirror(os.Stdout)
+		t.Render()
+	}
 	fmt.Printf("\n\n\033[1;34m--------------------------------------------------------------------------------\033[0m\n" +
 		"                            Done with benchmarking\n" +
 		"\033[1;34m--------------------------------------------------------------------------------\033[0m\n\n")
 }
 
-func getSamples(results []*Result) (stats.Float64Data, stats.Float64Data, stats.Float64Data) {
-	var allExecutionsSample stats.Float64Data
-	var successfulExecutionSample stats.Float64Data
-	var failedExecutionSample stats.Float64Data
-
-	for _, result := range results {
-		duration := math.Round(result.Duration*1000) / 1000
-		allExecutionsSample = append(allExecutionsSample, duration)
-		if result.Success {
-			successfulExecutionSample = append(successfulExecutionSample, duration)
-		} else {
-			failedExecutionSample = append(failedExecutionSample, duration)
-		}
+// printDryRun prints the operations planned for the mode.
+func printDryRun(mode string, report *bench.DryRunReport) {
+	if report == nil {
+		return
 	}
-
-	return allExecutionsSample, successfulExecutionSample, failedExecutionSample
-}
-
-func getRowFromSample(key string, sample stats.Float64Data) table.Row {
-	min, _ := sample.Min()
-	min = math.Round(min*1000) / 1000
-	max, _ := sample.Max()
-	max = math.Round(max*1000) / 1000
-	mean, _ := sample.Mean()
-	mean = math.Round(mean*1000) / 1000
-	median, _ := sample.Median()
-	median = math.Round(median*1000) / 1000
-	percentile90, _ := sample.Percentile(90)
-	percentile90 = math.Round(percentile90*1000) / 1000
-	percentile95, _ := sample.Percentile(95)
-	percentile95 = math.Round(percentile95*1000) / 1000
-	percentile99, _ := sample.Percentile(99)
-	percentile99 = math.Round(percentile99*1000) / 1000
-
-	return table.Row{key, len(sample), min, max, mean, median, percentile90, percentile95, percentile99}
+	if len(report.Operations) == 0 {
+		fmt.Printf("Dry run: %s would make no changes\n", mode)
+		return
+	}
+	fmt.Printf("Dry run: %s would make %d API calls\n", mode, len(report.Operations))
+	t := report.Table()
+	t.SetOutputMirror(os.Stdout)
+	t.Render()
 }
 
-/*
-This function will generate a report with the following details:
- 1. Total Number of executions
- 2. Number of successful executions
- 3. Number of failed exections
- 4. Different statistics like min, max, avg, median, 90th percentile, 95th percentile, 99th percentile for above 3
-
-Output format:
- 1. CSV
- 2. TSV
- 3. Table
-*/
-func generateReport(results map[string][]*Result, format string, outputFile string) {
+func generateReport(results bench.Results, format string, outputFile string, interrupted bool) {
 	fmt.Println("Generating report")
 
-	t := table.NewWriter()
-	t.SetOutputMirror(os.Stdout)
-	t.AppendHeader(table.Row{"Type", "Count", "Min", "Max", "Avg", "Median", "90th percentile", "95th percentile", "99th percentile"})
-
-	for key, result := range results {
-		allExecutionsSample, successfulExecutionSample, failedExecutionSample := getSamples(result)
-		t.AppendRow(getRowFromSample(fmt.Sprintf("%s - All", key), allExecutionsSample))
-
-		if failedExecutionSample.Len() != 0 {
-			t.AppendRow(getRowFromSample(fmt.Sprintf("%s - Successful", key), successfulExecutionSample))
-			t.AppendRow(getRowFromSample(fmt.Sprintf("%s - Failed", key), failedExecutionSample))
-		}
-	}
-
+	var out io.Writer = os.Stdout
 	if outputFile != "" {
 		f, err := os.Create(outputFile)
 		if err != nil {
 			log.Error("Error creating file: ", err)
 		}
 		defer f.Close()
-		t.SetOutputMirror(f)
-	}
-	switch format {
-	case "csv":
-		t.RenderCSV()
-	case "tsv":
-		t.RenderTSV()
-	case "table":
-		t.Render()
+		out = f
 	}
+	bench.WriteReport(results, format, out, interrupted)
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the commands given on the command line and returns the exit status.
+// Once the run context is set up, failures return instead of exiting, so that
+// the context is cancelled and the image server closed.
+func run() int {
 	dbprofile := flag.Int("dbprofile", 0, "DB profile number")
 	create := flag.Bool("create", false, "Create resources")
 	benchmark := flag.Bool("benchmark", false, "Benchmark list APIs")
 	domainFlag := flag.Bool("domain", false, "Create domain")
 	limitsFlag := flag.Bool("limits", false, "Update limits to -1")
 	networkFlag := flag.Bool("network", false, "Create shared network")
+	vpcFlag := flag.Bool("vpc", false, "Create VPCs with network ACLs and tiers")
+	isolatedFlag := flag.Bool("isolated", false, "Create isolated networks and acquire public IPs for them")
+	staticNatFlag := flag.Bool("staticnat", false, "Enable static NAT from the public IPs of the isolated networks to their VMs")
+	rulesFlag := flag.Bool("rules", false, "Create firewall, egress, port forwarding and load balancer rules for the isolated networks and their public IPs")
 	vmFlag := flag.Bool("vm", false, "Deploy VMs")
 	volumeFlag := flag.Bool("volume", false, "Attach Volumes to VMs")
-	tearDown := flag.Bool("teardown", false, "Tear down all subdomains")
-	workers := flag.Int("workers", 10, "number of workers to use while creating resources")
-	format := flag.String("format", "table", "Format of the report (csv, tsv, table). Valid only for create")
-	outputFile := flag.String("output", "", "Path to output file. Valid only for create")
+	snapshotFlag := flag.Bool("snapshot", false, "Take volume and VM snapshots of the VMs, and create snapshot policies for their volumes if snapshotpolicy is set")
+	imagesFlag := flag.Bool("images", false, "Register templates and ISOs from templateurl and isourl for every account, with the visibilities of imagevisibility")
+	imageServer := flag.String("image-server", "", "Address, e.g. :8090, to serve generated .qcow2 and .iso images on for templateurl and isourl, until interrupted")
+	topologyFile := flag.String("topology", "", "Path to a topology file describing the resources to create, instead of the -domain, -limits, -network, -vpc, -isolated, -vm, -staticnat, -rules, -volume, -snapshot and -images stages")
+	domainLevels := flag.Bool("domain-levels", false, "Also benchmark the domain-scoped list APIs in a domain at each level of the domain tree under the parent domain")
+	tearDown := flag.Bool("teardown", false, "Delete the resources recorded in the inventory file, only the ones of the -domain, -network, -vpc, -isolated, -vm, -volume, -snapshot and -images stages if given")
+	bootstrap := flag.Bool("bootstrap", false, "Create the user and domainadmin accounts with their API keys and write them to the config file")
+	preflight := flag.Bool("preflight", false, "Check that the environment is ready for the selected create stages (all if none) and the benchmark, and print a go/no-go report")
+	discover := flag.Bool("discover", false, "Resolve the zone, offering and template IDs and write them to the config file")
+	configOutput := flag.String("config-output", "", "Path to write the config file updated by -bootstrap or -discover to. Defaults to the -config file")
+	workers := flag.Int("workers", 10, "number of workers to use while creating or deleting resources")
+	format := flag.String("format", "table", "Format of the report (csv, tsv, table). Valid only for create and teardown")
+	outputFile := flag.String("output", "", "Path to output file. Valid only for create and teardown")
 	configFile := flag.String("config", "config/config", "Path to config file")
+	dashboardFlag := flag.Bool("dashboard", false, "Show a live dashboard while running. Falls back to plain logs if stdout is not a terminal")
+	shutdownTimeout := flag.Duration("shutdown-timeout", bench.DefaultShutdownTimeout, "Time to wait for in-flight requests when interrupted")
+	inventoryFile := flag.String("inventory", bench.DefaultInventoryFile, "Path to the file recording the resources created, which -teardown deletes")
+	dryRun := flag.Bool("dry-run", false, "With -create or -teardown, list the existing resources and print the operations which would be made, without making any change")
+	runId := flag.String("run-id", "", "ID of the run, in the names and tags of the resources created. With -teardown and -benchmark, only use the resources of that run")
+	stateFile := flag.String("state-file", bench.DefaultStateFile, "Path to the file saving the progress of -create, to resume it. Empty to disable")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: go run csmetrictool.go -dbprofile <DB profile number>\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
@@ -210,12 +252,21 @@ func main() {
 	}
 	flag.Parse()
 
-	if !(*create || *benchmark || *tearDown) {
-		log.Fatal("Please provide one of the following options: -create, -benchmark, -teardown")
+	if !(*bootstrap || *discover || *preflight || *create || *benchmark || *tearDown || *imageServer != "") {
+		log.Fatal("Please provide one of the following options: -bootstrap, -discover, -preflight, -create, -benchmark, -teardown, -image-server")
 	}
 
-	if *create && !(*domainFlag || *limitsFlag || *networkFlag || *vmFlag || *volumeFlag) {
-		log.Fatal("Please provide one of the following options with create: -domain, -limits, -network, -vm, -volume")
+	if *create && *topologyFile == "" && !(*domainFlag || *limitsFlag || *networkFlag || *vpcFlag || *isolatedFlag || *vmFlag || *staticNatFlag || *rulesFlag || *volumeFlag || *snapshotFlag || *imagesFlag) {
+		log.Fatal("Please provide one of the following options with create: -topology, -domain, -limits, -network, -vpc, -isolated, -vm, -staticnat, -rules, -volume, -snapshot, -images")
+	}
+	if *create && *topologyFile != "" && (*domainFlag || *limitsFlag || *networkFlag || *vpcFlag || *isolatedFlag || *vmFlag || *staticNatFlag || *rulesFlag || *volumeFlag || *snapshotFlag || *imagesFlag) {
+		log.Fatal("-topology cannot be used with -domain, -limits, -network, -vpc, -isolated, -vm, -staticnat, -rules, -volume, -snapshot, -images")
+	}
+	if *dryRun && !(*create || *tearDown) {
+		log.Fatal("-dry-run can only be used with -create or -teardown")
+	}
+	if *dryRun && (*bootstrap || *topologyFile != "") {
+		log.Fatal("-dry-run cannot be used with -bootstrap or -topology")
 	}
 
 	switch *format {
@@ -229,310 +280,199 @@ func main() {
 		log.Fatal("Invalid DB profile number. Please provide a positive integer.")
 	}
 
-	profiles = readConfigurations(*configFile)
-	apiURL := config.URL
-	iterations := config.Iterations
-	page := config.Page
-	pagesize := config.PageSize
-
-	if *create {
-		results := createResources(domainFlag, limitsFlag, networkFlag, vmFlag, volumeFlag, workers)
-		generateReport(results, *format, *outputFile)
+	cfg, err := bench.ConfigFromFile(*configFile)
+	if err != nil {
+		log.Errorf("Error reading the configuration: %s", err)
+		fmt.Fprintf(os.Stderr, "Error reading the configuration:\n%s\n", err)
+		return 1
 	}
-
-	if *benchmark {
-		log.Infof("\nStarted benchmarking the CloudStack environment [%s]", apiURL)
-
-		logConfigurationDetails(profiles)
-
-		for i, profile := range profiles {
-			userProfileName := profile.Name
-			log.Infof("Using profile %d.%s for benchmarking", i, userProfileName)
-			fmt.Printf("\n\033[1;34m============================================================\033[0m\n")
-			fmt.Printf("                    Profile: [%s]\n", userProfileName)
-			fmt.Printf("\033[1;34m============================================================\033[0m\n")
-			apirunner.RunAPIs(userProfileName, apiURL, profile.ApiKey, profile.SecretKey, profile.Expires, profile.SignatureVersion, iterations, page, pagesize, *dbprofile)
+	formatter := log.StandardLogger().Formatter
+	log.SetFormatter(&logger.MaskingFormatter{Formatter: formatter, Secrets: cfg.Secrets()})
+	var topo *topology.Topology
+	if *create && *topologyFile != "" {
+		topo, err = topology.ReadFile(*topologyFile)
+		if err != nil {
+			log.Errorf("Error reading the topology: %s", err)
+			fmt.Fprintf(os.Stderr, "Error reading the topology:\n%s\n", err)
+			return 1
 		}
-		logReport()
-
-		log.Infof("Done with benchmarking the CloudStack environment [%s]", apiURL)
 	}
-
-	if *tearDown {
-		tearDownEnv()
-	}
-}
-
-func createResources(domainFlag, limitsFlag, networkFlag, vmFlag, volumeFlag *bool, workers *int) map[string][]*Result {
-	apiURL := config.URL
-
-	for _, profile := range profiles {
-		if profile.Name == "admin" {
-
-			numVmsPerNetwork := config.NumVms
-			numVolumesPerVM := config.NumVolumes
-
-			cs := cloudstack.NewAsyncClient(apiURL, profile.ApiKey, profile.SecretKey, false)
-
-			var results = make(map[string][]*Result)
-
-			if *domainFlag {
-				workerPool := pool.NewWithResults[*Result]().WithMaxGoroutines(*workers)
-				results["domain"] = createDomains(workerPool, cs, config.ParentDomainId, config.NumDomains)
-			}
-
-			if *limitsFlag {
-				workerPool := pool.NewWithResults[*Result]().WithMaxGoroutines(*workers)
-				results["limits"] = updateLimits(workerPool, cs, config.ParentDomainId)
-			}
-
-			if *networkFlag {
-				workerPool := pool.NewWithResults[*Result]().WithMaxGoroutines(*workers)
-				results["network"] = createNetwork(workerPool, cs, config.ParentDomainId)
-			}
-
-			if *vmFlag {
-				workerPool := pool.NewWithResults[*Result]().WithMaxGoroutines(*workers)
-				results["vm"] = createVms(workerPool, cs, config.ParentDomainId, numVmsPerNetwork)
-			}
-
-			if *volumeFlag {
-				workerPool := pool.NewWithResults[*Result]().WithMaxGoroutines(*workers)
-				results["volume"] = createVolumes(workerPool, cs, config.ParentDomainId, numVolumesPerVM)
-			}
-
-			return results
+	if *runId != "" {
+		if err := cfg.SetRunId(*runId); err != nil {
+			log.Fatal(err)
 		}
 	}
-	return nil
-}
-
-func createDomains(workerPool *pool.ResultPool[*Result], cs *cloudstack.CloudStackClient, parentDomainId string, count int) []*Result {
-	progressMarker := int(math.Ceil(float64(count) / 10.0))
-	start := time.Now()
-	log.Infof("Creating %d domains", count)
-	for i := 0; i < count; i++ {
-		if (i+1)%progressMarker == 0 {
-			log.Infof("Created %d domains", i+1)
+	apiURL := cfg.URL
+	b := bench.New(cfg)
+	cfg = b.Config()
+	b.ShutdownTimeout = *shutdownTimeout
+	b.StateFile = *stateFile
+	b.InventoryFile = *inventoryFile
+
+	ctx, cancel := interruptibleContext(*shutdownTimeout)
+	defer cancel()
+
+	// The image server is started first and kept running until interrupted,
+	// as the templates and ISOs registered are downloaded after the
+	// registration returns.
+	var images *image.Server
+	if *imageServer != "" {
+		if images, err = image.NewServer(*imageServer); err != nil {
+			log.Errorf("Error starting the image server: %s", err)
+			return 1
 		}
-		workerPool.Go(func() *Result {
-			taskStart := time.Now()
-			dmn, err := domain.CreateDomain(cs, parentDomainId)
-			if err != nil {
-				return &Result{
-					Success:  false,
-					Duration: time.Since(taskStart).Seconds(),
-				}
-			}
-			_, err = domain.CreateAccount(cs, dmn.Id)
-			if err != nil {
-				return &Result{
-					Success:  false,
-					Duration: time.Since(taskStart).Seconds(),
-				}
-			}
-
-			return &Result{
-				Success:  true,
-				Duration: time.Since(taskStart).Seconds(),
-			}
-		})
+		defer images.Close()
+		log.Infof("Serving images on %s", images.Addr())
 	}
-	res := workerPool.Wait()
-	log.Infof("Created %d domains in %.2f seconds", count, time.Since(start).Seconds())
-	return res
-}
 
-func updateLimits(workerPool *pool.ResultPool[*Result], cs *cloudstack.CloudStackClient, parentDomainId string) []*Result {
-	log.Infof("Fetching subdomains for domain %s", parentDomainId)
-	domains := domain.ListSubDomains(cs, parentDomainId)
-	accounts := make([]*cloudstack.Account, 0)
-	for _, dmn := range domains {
-		accounts = append(accounts, domain.ListAccounts(cs, dmn.Id)...)
+	// -bootstrap and -discover update the config file in turn.
+	source, output := *configFile, *configOutput
+	if output == "" {
+		output = *configFile
 	}
 
-	progressMarker := int(math.Ceil(float64(len(accounts)) / 10.0))
-	start := time.Now()
-	log.Infof("Updating limits for %d accounts", len(accounts))
-	for i, account := range accounts {
-		if (i+1)%progressMarker == 0 {
-			log.Infof("Updated limits for %d accounts", i+1)
+	if *bootstrap {
+		profiles, bootstrapErr := b.Bootstrap(ctx)
+		for _, profile := range profiles {
+			cfg.SetProfile(profile)
 		}
-		account := account
-		workerPool.Go(func() *Result {
-			taskStart := time.Now()
-			resp := domain.UpdateLimits(cs, account)
-			return &Result{
-				Success:  resp,
-				Duration: time.Since(taskStart).Seconds(),
+		log.SetFormatter(&logger.MaskingFormatter{Formatter: formatter, Secrets: cfg.Secrets()})
+		// The keys of the accounts created before a failure are written too,
+		// as they cannot be read back from CloudStack.
+		if len(profiles) > 0 {
+			if err := config.UpdateFile(source, output, nil, profiles); err != nil {
+				log.Errorf("Error writing the config file %s: %s", output, err)
+				return 1
 			}
-		})
-	}
-	res := workerPool.Wait()
-	log.Infof("Updated limits for %d accounts in %.2f seconds", len(accounts), time.Since(start).Seconds())
-	return res
-}
-
-func createNetwork(workerPool *pool.ResultPool[*Result], cs *cloudstack.CloudStackClient, parentDomainId string) []*Result {
-	log.Infof("Fetching subdomains for domain %s", parentDomainId)
-	domains := domain.ListSubDomains(cs, parentDomainId)
-
-	progressMarker := int(math.Ceil(float64(len(domains)) / 10.0))
-	start := time.Now()
-	log.Infof("Creating %d networks", len(domains))
-	for i, dmn := range domains {
-		if (i+1)%progressMarker == 0 {
-			log.Infof("Created %d networks", i+1)
+			log.Infof("Wrote the profiles of the bootstrapped accounts to %s", output)
+			source = output
+		}
+		if bootstrapErr != nil {
+			log.Errorf("Error bootstrapping the accounts: %s", bootstrapErr)
+			return 1
 		}
-		i := i
-		dmn := dmn
-		workerPool.Go(func() *Result {
-			taskStart := time.Now()
-			_, err := network.CreateNetwork(cs, dmn.Id, i)
-			if err != nil {
-				return &Result{
-					Success:  false,
-					Duration: time.Since(taskStart).Seconds(),
-				}
-			}
-			return &Result{
-				Success:  true,
-				Duration: time.Since(taskStart).Seconds(),
-			}
-		})
-	}
-	res := workerPool.Wait()
-	log.Infof("Created %d networks in %.2f seconds", len(domains), time.Since(start).Seconds())
-	return res
-}
-
-func createVms(workerPool *pool.ResultPool[*Result], cs *cloudstack.CloudStackClient, parentDomainId string, numVmPerNetwork int) []*Result {
-	log.Infof("Fetching subdomains & accounts for domain %s", parentDomainId)
-	domains := domain.ListSubDomains(cs, parentDomainId)
-	var accounts []*cloudstack.Account
-	for i := 0; i < len(domains); i++ {
-		account := domain.ListAccounts(cs, domains[i].Id)
-		accounts = append(accounts, account...)
 	}
 
-	domainIdAccountMapping := make(map[string]*cloudstack.Account)
-	for _, account := range accounts {
-		domainIdAccountMapping[account.Domainid] = account
+	if *discover && ctx.Err() == nil {
+		if err := b.Discover(ctx, bench.Stages{Network: true, Vpc: true, Isolated: true, Vm: true, Volume: true}); err != nil {
+			log.Errorf("Error discovering the zones, offerings and template: %s", err)
+			return 1
+		}
+		settings := cfg.Settings("zoneid", "networkofferingid", "serviceofferingid", "diskofferingid", "templateid", "vpcofferingid", "vpctierofferingid", "isolatedofferingid")
+		for _, key := range []string{"zone", "networkoffering", "serviceoffering", "diskoffering", "template", "vpcoffering", "vpctieroffering", "isolatedoffering"} {
+			settings[key] = ""
+		}
+		if err := config.UpdateFile(source, output, settings, nil); err != nil {
+			log.Errorf("Error writing the config file %s: %s", output, err)
+			return 1
+		}
+		log.Infof("Wrote the discovered IDs to %s", output)
 	}
 
-	log.Infof("Fetching networks for subdomains in domain %s", parentDomainId)
-	var allNetworks []*cloudstack.Network
-	for _, domain := range domains {
-		network, _ := network.ListNetworks(cs, domain.Id)
-		allNetworks = append(allNetworks, network...)
+	stages := bench.Stages{
+		Domain:    *domainFlag,
+		Limits:    *limitsFlag,
+		Network:   *networkFlag,
+		Vpc:       *vpcFlag,
+		Isolated:  *isolatedFlag,
+		Vm:        *vmFlag,
+		StaticNat: *staticNatFlag,
+		Rules:     *rulesFlag,
+		Volume:    *volumeFlag,
+		Snapshot:  *snapshotFlag,
+		Images:    *imagesFlag,
 	}
 
-	progressMarker := int(math.Ceil(float64(len(allNetworks)*numVmPerNetwork) / 10.0))
-	start := time.Now()
-	log.Infof("Creating %d VMs", len(allNetworks)*numVmPerNetwork)
-	for i, network := range allNetworks {
-		network := network
-		for j := 1; j <= numVmPerNetwork; j++ {
-
-			if (i*j+j)%progressMarker == 0 {
-				log.Infof("Created %d VMs", i*j+j)
-			}
-			workerPool.Go(func() *Result {
-				taskStart := time.Now()
-				_, err := vm.DeployVm(cs, network.Domainid, network.Id, domainIdAccountMapping[network.Domainid].Name)
-				if err != nil {
-					return &Result{
-						Success:  false,
-						Duration: time.Since(taskStart).Seconds(),
-					}
-				}
-				return &Result{
-					Success:  true,
-					Duration: time.Since(taskStart).Seconds(),
-				}
-			})
+	if *preflight && ctx.Err() == nil {
+		preflightStages := stages
+		if preflightStages == (bench.Stages{}) {
+			preflightStages = bench.Stages{Domain: true, Limits: true, Network: true, Vpc: true, Isolated: true, Vm: true, StaticNat: true, Rules: true, Volume: true, Snapshot: true, Images: true}
+		}
+		report, err := b.Preflight(ctx, preflightStages, bench.Scenario{})
+		if err != nil {
+			log.Error("Error running the preflight checks: ", err)
+		}
+		if report != nil {
+			t := report.Table()
+			t.SetOutputMirror(os.Stdout)
+			t.Render()
+		}
+		// The checks of a preflight which failed or was interrupted are incomplete.
+		if err != nil || report == nil {
+			fmt.Printf("\033[1;31mNO-GO\033[0m: the preflight checks did not complete\n")
+			return 1
 		}
+		if !report.Go() {
+			fmt.Printf("\033[1;31mNO-GO\033[0m: fix the failed checks before running csbench\n")
+			return 1
+		}
+		fmt.Printf("\033[1;32mGO\033[0m\n")
 	}
-	res := workerPool.Wait()
-	log.Infof("Created %d VMs in %.2f seconds", len(allNetworks)*numVmPerNetwork, time.Since(start).Seconds())
-	return res
-}
 
-func createVolumes(workerPool *pool.ResultPool[*Result], cs *cloudstack.CloudStackClient, parentDomainId string, numVolumesPerVM int) []*Result {
-	log.Infof("Fetching all VMs in subdomains for domain %s", parentDomainId)
-	domains := domain.ListSubDomains(cs, parentDomainId)
-	var allVMs []*cloudstack.VirtualMachine
-	for _, dmn := range domains {
-		vms, err := vm.ListVMs(cs, dmn.Id)
+	if *create && *dryRun && ctx.Err() == nil {
+		report, err := b.PlanCreate(ctx, stages)
+		if err != nil {
+			log.Error("Error planning the creation of resources: ", err)
+		}
+		printDryRun("create", report)
+	} else if *create && ctx.Err() == nil {
+		dash, stopDashboard := startDashboard(*dashboardFlag, fmt.Sprintf("Creating resources in the CloudStack environment [%s]", apiURL))
+		b.Dashboard = dash
+		var results bench.Results
+		var err error
+		if topo != nil {
+			results, err = b.CreateTopology(ctx, topo, *workers)
+		} else {
+			results, err = b.Create(ctx, stages, *workers)
+		}
+		stopDashboard()
 		if err != nil {
-			log.Warn("Error listing VMs: ", err)
-			continue
+			log.Error("Error creating resources: ", err)
 		}
-		allVMs = append(allVMs, vms...)
+		generateReport(results, *format, *outputFile, ctx.Err() != nil)
 	}
 
-	progressMarker := int(math.Ceil(float64(len(allVMs)*numVolumesPerVM) / 10.0))
-	start := time.Now()
+	if *benchmark && ctx.Err() == nil {
+		log.Infof("\nStarted benchmarking the CloudStack environment [%s]", apiURL)
 
-	log.Infof("Creating %d volumes", len(allVMs)*numVolumesPerVM)
-	unsuitableVmCount := 0
+		logConfigurationDetails(cfg)
 
-	for i, vm := range allVMs {
-		vm := vm
-		if vm.State != "Running" && vm.State != "Stopped" {
-			unsuitableVmCount++
-			continue
+		dash, stopDashboard := startDashboard(*dashboardFlag, fmt.Sprintf("Benchmarking the CloudStack environment [%s]", apiURL))
+		b.Dashboard = dash
+		result, err := b.Benchmark(ctx, bench.Scenario{DBProfile: *dbprofile, DomainLevels: *domainLevels, RunId: *runId})
+		stopDashboard()
+		if err != nil {
+			log.Error("Error benchmarking: ", err)
 		}
-		for j := 1; j <= numVolumesPerVM; j++ {
-			if (i*j+j)%progressMarker == 0 {
-				log.Infof("Created %d volumes", i*j+j)
-			}
-
-			workerPool.Go(func() *Result {
-				taskStart := time.Now()
-				vol, err := volume.CreateVolume(cs, vm.Domainid, vm.Account)
-				if err != nil {
-					return &Result{
-						Success:  false,
-						Duration: time.Since(taskStart).Seconds(),
-					}
-				}
-				_, err = volume.AttachVolume(cs, vol.Id, vm.Id)
-				if err != nil {
-					return &Result{
-						Success:  false,
-						Duration: time.Since(taskStart).Seconds(),
-					}
-				}
-				return &Result{
-					Success:  true,
-					Duration: time.Since(taskStart).Seconds(),
-				}
-			})
+		if result != nil {
+			logReport(cfg, result.Summary, result.Interrupted)
 		}
-	}
-	if unsuitableVmCount > 0 {
-		log.Warnf("Found %d VMs in unsuitable state", unsuitableVmCount)
-	}
-	res := workerPool.Wait()
-	log.Infof("Created %d volumes in %.2f seconds", (len(allVMs)-unsuitableVmCount)*numVolumesPerVM, time.Since(start).Seconds())
-	return res
-}
 
-func tearDownEnv() {
-	parentDomain := config.ParentDomainId
-	apiURL := config.URL
+		log.Infof("Done with benchmarking the CloudStack environment [%s]", apiURL)
+	}
 
-	for _, profile := range profiles {
-		userProfileName := profile.Name
-		if userProfileName == "admin" {
-			cs := cloudstack.NewAsyncClient(apiURL, profile.ApiKey, profile.SecretKey, false)
-			domains := domain.ListSubDomains(cs, parentDomain)
-			log.Infof("Deleting %d domains", len(domains))
-			for _, subdomain := range domains {
-				domain.DeleteDomain(cs, subdomain.Id)
-			}
-			break
+	if *tearDown && *dryRun && ctx.Err() == nil {
+		report, err := b.PlanTearDown(ctx, stages, *runId)
+		if err != nil {
+			log.Error("Error planning the tear down of the environment: ", err)
+		}
+		printDryRun("teardown", report)
+	} else if *tearDown && ctx.Err() == nil {
+		dash, stopDashboard := startDashboard(*dashboardFlag, fmt.Sprintf("Tearing down the CloudStack environment [%s]", apiURL))
+		b.Dashboard = dash
+		results, err := b.TearDown(ctx, stages, *runId, *workers)
+		stopDashboard()
+		if err != nil {
+			log.Error("Error tearing down the environment: ", err)
 		}
+		if len(results) > 0 {
+			generateReport(results, *format, *outputFile, ctx.Err() != nil)
+		}
+	}
+
+	if images != nil && ctx.Err() == nil {
+		log.Info("Serving images until interrupted")
+		<-ctx.Done()
 	}
+	return 0
 }
@@ -0,0 +1,233 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dashboard
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	refreshInterval = 250 * time.Millisecond
+	sparklineWidth  = 30
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+type commandStats struct {
+	inFlight  int
+	done      int
+	failed    int
+	totalTime float64
+	latencies []float64
+}
+
+// Dashboard renders a live view of a running benchmark on a terminal. A nil
+// *Dashboard is valid and turns every method into a no-op, so callers don't
+// need to check whether the dashboard is enabled.
+type Dashboard struct {
+	mu       sync.Mutex
+	out      io.Writer
+	title    string
+	total    int
+	start    time.Time
+	commands map[string]*commandStats
+	lines    int
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// IsTerminal reports whether f is attached to a character device.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// New returns a dashboard rendering to stdout, or nil if stdout is not a
+// terminal, in which case the caller should keep using plain logs.
+func New(title string) *Dashboard {
+	if !IsTerminal(os.Stdout) {
+		return nil
+	}
+	return &Dashboard{
+		out:      os.Stdout,
+		title:    title,
+		commands: make(map[string]*commandStats),
+	}
+}
+
+// Start begins redrawing the dashboard periodically until Stop is called.
+func (d *Dashboard) Start() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.start = time.Now()
+	d.stop = make(chan struct{})
+	d.stopped = make(chan struct{})
+	d.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		defer close(d.stopped)
+		for {
+			select {
+			case <-ticker.C:
+				d.render()
+			case <-d.stop:
+				d.render()
+				return
+			}
+		}
+	}()
+}
+
+// Stop draws the final state of the dashboard and stops redrawing it.
+func (d *Dashboard) Stop() {
+	if d == nil || d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.stopped
+	d.stop = nil
+}
+
+// AddTotal increases the number of requests expected in this run, which is
+// used to compute the progress and the ETA.
+func (d *Dashboard) AddTotal(n int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.total += n
+}
+
+// Begin records that a request for command has been sent.
+func (d *Dashboard) Begin(command string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats(command).inFlight++
+}
+
+// End records that a request for command has completed.
+func (d *Dashboard) End(command string, duration float64, success bool) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.stats(command)
+	s.inFlight--
+	s.done++
+	s.totalTime += duration
+	if !success {
+		s.failed++
+	}
+	s.latencies = append(s.latencies, duration)
+	if len(s.latencies) > sparklineWidth {
+		s.latencies = s.latencies[len(s.latencies)-sparklineWidth:]
+	}
+}
+
+func (d *Dashboard) stats(command string) *commandStats {
+	s, ok := d.commands[command]
+	if !ok {
+		s = &commandStats{}
+		d.commands[command] = s
+	}
+	return s
+}
+
+func (d *Dashboard) render() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names := make([]string, 0, len(d.commands))
+	for name := range d.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	inFlight, done, failed := 0, 0, 0
+	for _, s := range d.commands {
+		inFlight += s.inFlight
+		done += s.done
+		failed += s.failed
+	}
+
+	var b strings.Builder
+	elapsed := time.Since(d.start)
+	fmt.Fprintf(&b, "\033[1;34m%s\033[0m\n", d.title)
+	fmt.Fprintf(&b, "Elapsed: %s  ETA: %s  Done: %d/%d  In flight: %d  Errors: %d\n\n",
+		elapsed.Round(time.Second), eta(elapsed, done, d.total), done, d.total, inFlight, failed)
+	fmt.Fprintf(&b, "%-30s %8s %8s %8s %8s  %s\n", "Command", "Flight", "Done", "Errors", "Avg(s)", "Latency")
+	for _, name := range names {
+		s := d.commands[name]
+		avg := 0.0
+		if s.done > 0 {
+			avg = s.totalTime / float64(s.done)
+		}
+		fmt.Fprintf(&b, "%-30s %8d %8d %8d %8.2f  %s\n", name, s.inFlight, s.done, s.failed, avg, sparkline(s.latencies))
+	}
+
+	if d.lines > 0 {
+		fmt.Fprintf(d.out, "\033[%dA\033[J", d.lines)
+	}
+	output := b.String()
+	d.lines = strings.Count(output, "\n")
+	fmt.Fprint(d.out, output)
+}
+
+func eta(elapsed time.Duration, done int, total int) string {
+	if done == 0 || total <= done {
+		return "-"
+	}
+	remaining := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+	return remaining.Round(time.Second).String()
+}
+
+func sparkline(values []float64) string {
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		idx := 0
+		if max > 0 {
+			idx = int(v / max * float64(len(sparks)-1))
+		}
+		line[i] = sparks[idx]
+	}
+	return string(line)
+}
@@ -25,8 +25,8 @@ import (
 	"github.com/apache/cloudstack-go/v2/cloudstack"
 )
 
-func CreateDomain(cs *cloudstack.CloudStackClient, parentDomainId string) (*cloudstack.CreateDomainResponse, error) {
-	domainName := "Domain-" + utils.RandomString(10)
+func CreateDomain(cs *cloudstack.CloudStackClient, cfg *config.Config, parentDomainId string) (*cloudstack.CreateDomainResponse, error) {
+	domainName := cfg.ResourceName(config.KindDomain)
 	p := cs.Domain.NewCreateDomainParams(domainName)
 	p.SetParentdomainid(parentDomainId)
 	resp, err := cs.Domain.CreateDomain(p)
@@ -44,16 +44,22 @@ func DeleteDomain(cs *cloudstack.CloudStackClient, domainId string) (bool, error
 	delResp, err := cs.Domain.DeleteDomain(deleteParams)
 	if err != nil {
 		log.Printf("Failed to delete domain with id  %s due to %v", domainId, err)
-		return delResp.Success, err
+		return false, err
 	}
 	return delResp.Success, nil
 }
 
-func CreateAccount(cs *cloudstack.CloudStackClient, domainId string) (*cloudstack.CreateAccountResponse, error) {
-	accountName := "Account-" + utils.RandomString(10)
+// Account types of the accounts created by CreateAccount.
+const (
+	AccountTypeUser        = 0
+	AccountTypeDomainAdmin = 2
+)
+
+func CreateAccount(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string, accountType int) (*cloudstack.CreateAccountResponse, error) {
+	accountName := cfg.ResourceName(config.KindAccount)
 	p := cs.Account.NewCreateAccountParams("test@test", accountName, "Account", "password", accountName)
 	p.SetDomainid(domainId)
-	p.SetAccounttype(2)
+	p.SetAccounttype(accountType)
 
 	resp, err := cs.Account.CreateAccount(p)
 
@@ -64,35 +70,114 @@ func CreateAccount(cs *cloudstack.CloudStackClient, domainId string) (*cloudstac
 	return resp, err
 }
 
-func ListSubDomains(cs *cloudstack.CloudStackClient, domainId string) []*cloudstack.DomainChildren {
-	p := cs.Domain.NewListDomainChildrenParams()
-	p.SetId(domainId)
-	p.SetPage(1)
-	p.SetPagesize(config.PageSize)
-	// TODO: Handle pagination to get all domains
-	resp, err := cs.Domain.ListDomainChildren(p)
+func DeleteAccount(cs *cloudstack.CloudStackClient, accountId string) (bool, error) {
+	p := cs.Account.NewDeleteAccountParams(accountId)
+	resp, err := cs.Account.DeleteAccount(p)
 	if err != nil {
-		log.Printf("Failed to list domains due to: %v", err)
-		return nil
+		log.Printf("Failed to delete account with id %s due to %v", accountId, err)
+		return false, err
 	}
-	return resp.DomainChildren
+	return resp.Success, nil
 }
 
-func ListAccounts(cs *cloudstack.CloudStackClient, domainId string) []*cloudstack.Account {
-	p := cs.Account.NewListAccountsParams()
+func CreateUser(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string, account string) (*cloudstack.CreateUserResponse, error) {
+	userName := cfg.ResourceName(config.KindUser)
+	p := cs.User.NewCreateUserParams(account, "test@test", userName, "User", "password", userName)
 	p.SetDomainid(domainId)
-	p.SetPage(1)
-	p.SetPagesize(config.PageSize)
-	// TODO: Handle pagination to get all domains
-	resp, err := cs.Account.ListAccounts(p)
+
+	resp, err := cs.User.CreateUser(p)
+	if err != nil {
+		log.Printf("Failed to create user due to: %v", err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func RegisterUserKeys(cs *cloudstack.CloudStackClient, userId string) (*cloudstack.RegisterUserKeysResponse, error) {
+	p := cs.User.NewRegisterUserKeysParams(userId)
+	resp, err := cs.User.RegisterUserKeys(p)
+	if err != nil {
+		log.Printf("Failed to register keys for user %s due to: %v", userId, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ListSubDomains lists the children of the domain, going through all the pages.
+func ListSubDomains(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.DomainChildren, error) {
+	return listDomainChildren(cs, cfg, domainId, false)
+}
+
+// ListAllSubDomains lists all the descendants of the domain, at any depth.
+func ListAllSubDomains(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.DomainChildren, error) {
+	return listDomainChildren(cs, cfg, domainId, true)
+}
+
+func listDomainChildren(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string, recursive bool) ([]*cloudstack.DomainChildren, error) {
+	domains, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.DomainChildren, int, error) {
+		p := cs.Domain.NewListDomainChildrenParams()
+		p.SetId(domainId)
+		if recursive {
+			p.SetIsrecursive(true)
+		}
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Domain.ListDomainChildren(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.DomainChildren, resp.Count, nil
+	})
 	if err != nil {
 		log.Printf("Failed to list domains due to: %v", err)
-		return nil
+		return nil, err
+	}
+	return domains, nil
+}
+
+// ListAccounts lists the accounts of the domain, going through all the pages.
+func ListAccounts(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.Account, error) {
+	accounts, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Account, int, error) {
+		p := cs.Account.NewListAccountsParams()
+		p.SetDomainid(domainId)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Account.ListAccounts(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Accounts, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list accounts due to: %v", err)
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// ListAllAccounts lists the accounts of the domain and of all its descendants.
+func ListAllAccounts(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.Account, error) {
+	accounts, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Account, int, error) {
+		p := cs.Account.NewListAccountsParams()
+		p.SetDomainid(domainId)
+		p.SetIsrecursive(true)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Account.ListAccounts(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Accounts, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list accounts due to: %v", err)
+		return nil, err
 	}
-	return resp.Accounts
+	return accounts, nil
 }
 
-func UpdateLimits(cs *cloudstack.CloudStackClient, account *cloudstack.Account) bool {
+func UpdateLimits(cs *cloudstack.CloudStackClient, account *cloudstack.Account) (bool, error) {
 	for i := 0; i <= 11; i++ {
 		p := cs.Limit.NewUpdateResourceLimitParams(i)
 		p.SetAccount(account.Name)
@@ -101,8 +186,8 @@ func UpdateLimits(cs *cloudstack.CloudStackClient, account *cloudstack.Account)
 		_, err := cs.Limit.UpdateResourceLimit(p)
 		if err != nil {
 			log.Printf("Failed to update resource limit due to: %v", err)
-			return false
+			return false, err
 		}
 	}
-	return true
+	return true, nil
 }
@@ -0,0 +1,202 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package failures
+
+import (
+	"csbench/config"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Failure describes a single failed CloudStack API call.
+type Failure struct {
+	API         string
+	Resource    string
+	HTTPStatus  int
+	ErrorCode   int
+	CSErrorCode int
+	ErrorText   string
+}
+
+// Summary is the number of occurrences of a distinct error for an API.
+type Summary struct {
+	Resource    string
+	API         string
+	HTTPStatus  int
+	ErrorCode   int
+	CSErrorCode int
+	ErrorText   string
+	Count       int
+}
+
+var (
+	apiErrorRegex = regexp.MustCompile(`(?s)^CloudStack API error (\d+) \(CSExceptionErrorCode: (\d+)\): (.*)$`)
+	uuidRegex     = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	ipRegex       = regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}\b`)
+	numberRegex   = regexp.MustCompile(`\b\d+\b`)
+	nameRegex     = regexp.MustCompile(`\b(` + strings.Join(config.ResourceKinds, "|") + `)-[a-zA-Z]+\b`)
+	spaceRegex    = regexp.MustCompile(`\s+`)
+)
+
+// New returns a failure for an API response carrying the CloudStack error details.
+func New(api string, httpStatus int, errorCode int, csErrorCode int, errorText string) *Failure {
+	return &Failure{
+		API:         api,
+		Resource:    ResourceType(api),
+		HTTPStatus:  httpStatus,
+		ErrorCode:   errorCode,
+		CSErrorCode: csErrorCode,
+		ErrorText:   Normalize(errorText),
+	}
+}
+
+/*
+FromError builds a failure from an error returned by the cloudstack-go client.
+The client reports synchronous API errors as
+"CloudStack API error <errorcode> (CSExceptionErrorCode: <cserrorcode>): <errortext>",
+where the errorcode is the HTTP status of the response, and failed async jobs
+as the JSON job result, optionally prefixed with "Undefined error: ".
+Any other error, e.g. a connection failure, is kept as the error text.
+*/
+func FromError(api string, err error) *Failure {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+
+	if m := apiErrorRegex.FindStringSubmatch(msg); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		csCode, _ := strconv.Atoi(m[2])
+		return New(api, code, code, csCode, m[3])
+	}
+
+	var jobResult struct {
+		ErrorCode   int    `json:"errorcode"`
+		CSErrorCode int    `json:"cserrorcode"`
+		ErrorText   string `json:"errortext"`
+	}
+	raw := strings.TrimPrefix(msg, "Undefined error: ")
+	if json.Unmarshal([]byte(raw), &jobResult) == nil && jobResult.ErrorText != "" {
+		return New(api, 0, jobResult.ErrorCode, jobResult.CSErrorCode, jobResult.ErrorText)
+	}
+
+	return New(api, 0, 0, 0, msg)
+}
+
+// ResourceType returns the resource an API operates on, e.g. "virtualmachines" for listVirtualMachines.
+func ResourceType(api string) string {
+	idx := strings.IndexFunc(api, unicode.IsUpper)
+	if idx < 0 {
+		return strings.ToLower(api)
+	}
+	return strings.ToLower(api[idx:])
+}
+
+// Normalize replaces the parts of an error text that vary between occurrences
+// of the same error, like IDs, addresses and generated names, with placeholders
+// so that identical errors can be grouped.
+func Normalize(text string) string {
+	text = uuidRegex.ReplaceAllString(text, "<uuid>")
+	text = ipRegex.ReplaceAllString(text, "<ip>")
+	text = nameRegex.ReplaceAllString(text, "$1-<name>")
+	text = numberRegex.ReplaceAllString(text, "<n>")
+	text = spaceRegex.ReplaceAllString(text, " ")
+	return strings.TrimSpace(text)
+}
+
+// Collector accumulates failures and is safe for concurrent use.
+type Collector struct {
+	mu       sync.Mutex
+	failures []*Failure
+}
+
+// Add records a failure. Nil failures are ignored.
+func (c *Collector) Add(f *Failure) {
+	if f == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = append(c.failures, f)
+}
+
+// Failures returns a copy of all the recorded failures.
+func (c *Collector) Failures() []*Failure {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Failure(nil), c.failures...)
+}
+
+/*
+Summarize groups identical failures and returns, for every resource type and
+API, the top most frequent errors ordered by resource type, API and descending
+number of occurrences. A top of 0 or less returns all the errors.
+*/
+func Summarize(failures []*Failure, top int) []*Summary {
+	grouped := make(map[Summary]int)
+	for _, f := range failures {
+		key := Summary{
+			Resource:    f.Resource,
+			API:         f.API,
+			HTTPStatus:  f.HTTPStatus,
+			ErrorCode:   f.ErrorCode,
+			CSErrorCode: f.CSErrorCode,
+			ErrorText:   f.ErrorText,
+		}
+		grouped[key]++
+	}
+
+	summaries := make([]*Summary, 0, len(grouped))
+	for key, count := range grouped {
+		s := key
+		s.Count = count
+		summaries = append(summaries, &s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.API != b.API {
+			return a.API < b.API
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.ErrorText < b.ErrorText
+	})
+
+	if top <= 0 {
+		return summaries
+	}
+	var result []*Summary
+	perAPI := make(map[string]int)
+	for _, s := range summaries {
+		key := s.Resource + "/" + s.API
+		if perAPI[key] < top {
+			result = append(result, s)
+			perAPI[key]++
+		}
+	}
+	return result
+}
@@ -0,0 +1,175 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package failures
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "uuid",
+			text: "Unable to find domain with id 3c1ac9b4-0f5e-11ee-9b61-5254001daa61",
+			want: "Unable to find domain with id <uuid>",
+		},
+		{
+			name: "ip",
+			text: "IP 10.1.1.23 is already in use",
+			want: "IP <ip> is already in use",
+		},
+		{
+			name: "number",
+			text: "Maximum number of resources of type 'user_vm' for account 42 has exceeded 20",
+			want: "Maximum number of resources of type 'user_vm' for account <n> has exceeded <n>",
+		},
+		{
+			name: "generated name",
+			text: "Network csbench-run-Network-AbCdEf already exists",
+			want: "Network csbench-run-Network-<name> already exists",
+		},
+		{
+			name: "every resource kind",
+			text: "Vpc-a Acl-b Tier-c Isolated-d LB-e Snapshot-f VmSnapshot-g Template-h Iso-i",
+			want: "Vpc-<name> Acl-<name> Tier-<name> Isolated-<name> LB-<name> Snapshot-<name> VmSnapshot-<name> Template-<name> Iso-<name>",
+		},
+		{
+			name: "unknown kind",
+			text: "Router-abc failed",
+			want: "Router-abc failed",
+		},
+		{
+			name: "whitespace",
+			text: "  failed \n to start\tvm ",
+			want: "failed to start vm",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.text); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Failure
+	}{
+		{
+			name: "api error",
+			err:  errors.New("CloudStack API error 431 (CSExceptionErrorCode: 4350): Unable to find account 7"),
+			want: Failure{API: "listAccounts", Resource: "accounts", HTTPStatus: 431, ErrorCode: 431, CSErrorCode: 4350, ErrorText: "Unable to find account <n>"},
+		},
+		{
+			name: "async job error",
+			err:  errors.New(`Undefined error: {"errorcode":530,"cserrorcode":4250,"errortext":"Insufficient capacity"}`),
+			want: Failure{API: "listAccounts", Resource: "accounts", ErrorCode: 530, CSErrorCode: 4250, ErrorText: "Insufficient capacity"},
+		},
+		{
+			name: "other error",
+			err:  errors.New("connection refused"),
+			want: Failure{API: "listAccounts", Resource: "accounts", ErrorText: "connection refused"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromError("listAccounts", tt.err)
+			if got == nil || *got != tt.want {
+				t.Errorf("FromError(%q) = %+v, want %+v", tt.err, got, tt.want)
+			}
+		})
+	}
+	if got := FromError("listAccounts", nil); got != nil {
+		t.Errorf("FromError(nil) = %+v, want nil", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var input []*Failure
+	add := func(api string, text string, count int) {
+		for i := 0; i < count; i++ {
+			input = append(input, New(api, 431, 431, 4350, text))
+		}
+	}
+	add("createTags", "tag a", 3)
+	add("createTags", "tag b", 2)
+	add("createTags", "tag c", 1)
+	add("createAccount", "account a", 1)
+	add("createAccount", "account b", 4)
+	add("createAccount", "account c", 2)
+
+	type row struct {
+		api   string
+		text  string
+		count int
+	}
+	tests := []struct {
+		name string
+		top  int
+		want []row
+	}{
+		{
+			name: "all",
+			top:  0,
+			want: []row{
+				{"createAccount", "account b", 4},
+				{"createAccount", "account c", 2},
+				{"createAccount", "account a", 1},
+				{"createTags", "tag a", 3},
+				{"createTags", "tag b", 2},
+				{"createTags", "tag c", 1},
+			},
+		},
+		{
+			name: "top per API",
+			top:  2,
+			want: []row{
+				{"createAccount", "account b", 4},
+				{"createAccount", "account c", 2},
+				{"createTags", "tag a", 3},
+				{"createTags", "tag b", 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Summarize(input, tt.top)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Summarize returned %d summaries, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				s := got[i]
+				if s.API != want.api || s.ErrorText != want.text || s.Count != want.count {
+					t.Errorf("summary %d = %s %q %d, want %s %q %d", i, s.API, s.ErrorText, s.Count, want.api, want.text, want.count)
+				}
+				if s.Resource != ResourceType(want.api) {
+					t.Errorf("summary %d resource = %q, want %q", i, s.Resource, ResourceType(want.api))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package image
+
+import (
+	"csbench/config"
+	"csbench/utils"
+	"fmt"
+	"log"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// The visibilities of the templates and ISOs registered, as set in the
+// imagevisibility setting.
+const (
+	VisibilityPrivate  = "private"
+	VisibilityPublic   = "public"
+	VisibilityFeatured = "featured"
+)
+
+// GetTemplate returns the template, to register the templates and ISOs with
+// its OS type and the templates with its hypervisor and format.
+func GetTemplate(cs *cloudstack.CloudStackClient, templateId string) (*cloudstack.Template, error) {
+	p := cs.Template.NewListTemplatesParams("executable")
+	p.SetId(templateId)
+	resp, err := cs.Template.ListTemplates(p)
+	if err != nil {
+		log.Printf("Failed to get template %s due to %v", templateId, err)
+		return nil, err
+	}
+	if len(resp.Templates) == 0 {
+		return nil, fmt.Errorf("template %s not found", templateId)
+	}
+	return resp.Templates[0], nil
+}
+
+// ListTemplates lists the templates owned by the accounts of the domain, going
+// through all the pages. A template registered in several zones is listed once.
+func ListTemplates(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.Template, error) {
+	templates, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Template, int, error) {
+		p := cs.Template.NewListTemplatesParams("all")
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetShowunique(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Template.ListTemplates(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Templates, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list templates due to %v", err)
+		return nil, err
+	}
+	// showunique is ignored by the versions of CloudStack which predate it.
+	seen := make(map[string]bool)
+	unique := templates[:0]
+	for _, t := range templates {
+		if !seen[t.Id] {
+			seen[t.Id] = true
+			unique = append(unique, t)
+		}
+	}
+	return unique, nil
+}
+
+// ListIsos lists the ISOs owned by the accounts of the domain, going through
+// all the pages. An ISO registered in several zones is listed once.
+func ListIsos(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.Iso, error) {
+	isos, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Iso, int, error) {
+		p := cs.ISO.NewListIsosParams()
+		p.SetIsofilter("all")
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetShowunique(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.ISO.ListIsos(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Isos, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list ISOs due to %v", err)
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	unique := isos[:0]
+	for _, iso := range isos {
+		if !seen[iso.Id] {
+			seen[iso.Id] = true
+			unique = append(unique, iso)
+		}
+	}
+	return unique, nil
+}
+
+// RegisterTemplate registers a template of the account from the templateurl
+// in the zone, with the hypervisor, format and OS type of the base template.
+func RegisterTemplate(cs *cloudstack.CloudStackClient, cfg *config.Config, base *cloudstack.Template, zoneId string, domainId string, account string, visibility string) (*cloudstack.RegisterTemplate, error) {
+	name := cfg.ResourceName(config.KindTemplate)
+	p := cs.Template.NewRegisterTemplateParams(name, base.Format, base.Hypervisor, name, cfg.TemplateUrl)
+	p.SetOstypeid(base.Ostypeid)
+	p.SetZoneid(zoneId)
+	p.SetDomainid(domainId)
+	p.SetAccount(account)
+	p.SetIspublic(visibility != VisibilityPrivate)
+	p.SetIsfeatured(visibility == VisibilityFeatured)
+	resp, err := cs.Template.RegisterTemplate(p)
+	if err != nil {
+		log.Printf("Failed to register a template due to: %v", err)
+		return nil, err
+	}
+	if len(resp.RegisterTemplate) == 0 {
+		return nil, fmt.Errorf("no template returned by registerTemplate")
+	}
+	return resp.RegisterTemplate[0], nil
+}
+
+// RegisterIso registers a bootable ISO of the account from the isourl in the
+// zone, with the OS type of the base template.
+func RegisterIso(cs *cloudstack.CloudStackClient, cfg *config.Config, base *cloudstack.Template, zoneId string, domainId string, account string, visibility string) (*cloudstack.RegisterIsoResponse, error) {
+	name := cfg.ResourceName(config.KindIso)
+	p := cs.ISO.NewRegisterIsoParams(name, name, cfg.IsoUrl, zoneId)
+	p.SetOstypeid(base.Ostypeid)
+	p.SetBootable(true)
+	p.SetDomainid(domainId)
+	p.SetAccount(account)
+	p.SetIspublic(visibility != VisibilityPrivate)
+	p.SetIsfeatured(visibility == VisibilityFeatured)
+	resp, err := cs.ISO.RegisterIso(p)
+	if err != nil {
+		log.Printf("Failed to register an ISO due to: %v", err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DeleteTemplate deletes the template from all the zones.
+func DeleteTemplate(cs *cloudstack.CloudStackClient, templateId string) (bool, error) {
+	p := cs.Template.NewDeleteTemplateParams(templateId)
+	resp, err := cs.Template.DeleteTemplate(p)
+	if err != nil {
+		log.Printf("Failed to delete template with id %s due to %v", templateId, err)
+		return false, err
+	}
+	return resp.Success, nil
+}
+
+// DeleteIso deletes the ISO from all the zones.
+func DeleteIso(cs *cloudstack.CloudStackClient, isoId string) (bool, error) {
+	p := cs.ISO.NewDeleteIsoParams(isoId)
+	resp, err := cs.ISO.DeleteIso(p)
+	if err != nil {
+		log.Printf("Failed to delete ISO with id %s due to %v", isoId, err)
+		return false, err
+	}
+	return resp.Success, nil
+}
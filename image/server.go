@@ -0,0 +1,193 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"time"
+)
+
+const (
+	qcow2ClusterSize = 1 << 16
+	isoSectorSize    = 2048
+)
+
+// Server serves small generated images over HTTP, so that the templates and
+// ISOs registered can be downloaded by the secondary storage VMs without an
+// image store of their own. Any path ending in .qcow2 serves an empty 1 MiB
+// QCOW2 disk and any path ending in .iso serves an empty ISO 9660 image.
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewServer starts serving the images on the address, in the background
+// until the server is closed.
+func NewServer(addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	images := map[string][]byte{
+		".qcow2": qcow2Image(),
+		".iso":   isoImage(),
+	}
+	s := &Server{
+		listener: listener,
+		server: &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				content, ok := images[path.Ext(r.URL.Path)]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				log.Printf("Serving %s %s to %s", r.Method, r.URL.Path, r.RemoteAddr)
+				http.ServeContent(w, r, path.Base(r.URL.Path), time.Time{}, bytes.NewReader(content))
+			}),
+			ReadHeaderTimeout: 30 * time.Second,
+		},
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Image server stopped due to %v", err)
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// qcow2Image returns a version 2 QCOW2 image of 1 MiB with no data clusters
+// allocated. The clusters hold in turn the header, the refcount table, the
+// refcount block and the L1 table.
+func qcow2Image() []byte {
+	image := make([]byte, 4*qcow2ClusterSize)
+	be := binary.BigEndian
+	copy(image, "QFI\xfb")
+	be.PutUint32(image[4:], 2)
+	be.PutUint32(image[20:], 16)
+	be.PutUint64(image[24:], 1<<20)
+	be.PutUint32(image[36:], 1)
+	be.PutUint64(image[40:], 3*qcow2ClusterSize)
+	be.PutUint64(image[48:], qcow2ClusterSize)
+	be.PutUint32(image[56:], 1)
+	be.PutUint64(image[qcow2ClusterSize:], 2*qcow2ClusterSize)
+	for cluster := 0; cluster < 4; cluster++ {
+		be.PutUint16(image[2*qcow2ClusterSize+2*cluster:], 1)
+	}
+	return image
+}
+
+// isoImage returns an ISO 9660 image holding an empty root directory, made of
+// the system area, the primary volume descriptor, the set terminator, the
+// little and big endian path tables and the root directory.
+func isoImage() []byte {
+	const (
+		pvdSector     = 16
+		lPathSector   = 18
+		mPathSector   = 19
+		rootDirSector = 20
+		sectors       = 21
+	)
+	image := make([]byte, sectors*isoSectorSize)
+
+	pvd := image[pvdSector*isoSectorSize:]
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	pvd[6] = 1
+	fill(pvd[8:40], ' ')
+	fill(pvd[40:72], ' ')
+	copy(pvd[40:], "CSBENCH")
+	putBothUint32(pvd[80:], sectors)
+	putBothUint16(pvd[120:], 1)
+	putBothUint16(pvd[124:], 1)
+	putBothUint16(pvd[128:], isoSectorSize)
+	putBothUint32(pvd[132:], 10)
+	binary.LittleEndian.PutUint32(pvd[140:], lPathSector)
+	binary.BigEndian.PutUint32(pvd[148:], mPathSector)
+	putDirRecord(pvd[156:], rootDirSector, 0)
+	fill(pvd[190:813], ' ')
+	for _, date := range []int{813, 830, 847, 864} {
+		fill(pvd[date:date+16], '0')
+	}
+	pvd[881] = 1
+
+	terminator := image[(pvdSector+1)*isoSectorSize:]
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	for _, table := range []struct {
+		sector int
+		order  binary.ByteOrder
+	}{{lPathSector, binary.LittleEndian}, {mPathSector, binary.BigEndian}} {
+		entry := image[table.sector*isoSectorSize:]
+		entry[0] = 1
+		table.order.PutUint32(entry[2:], rootDirSector)
+		table.order.PutUint16(entry[6:], 1)
+	}
+
+	rootDir := image[rootDirSector*isoSectorSize:]
+	putDirRecord(rootDir, rootDirSector, 0)
+	putDirRecord(rootDir[34:], rootDirSector, 1)
+	return image
+}
+
+// putDirRecord writes the 34 byte record of the root directory, named by the
+// single byte 0 for itself and 1 for its parent.
+func putDirRecord(record []byte, sector uint32, name byte) {
+	record[0] = 34
+	putBothUint32(record[2:], sector)
+	putBothUint32(record[10:], isoSectorSize)
+	record[18] = 100
+	record[19] = 1
+	record[20] = 1
+	record[25] = 0x02
+	putBothUint16(record[28:], 1)
+	record[32] = 1
+	record[33] = name
+}
+
+func putBothUint32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func putBothUint16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func fill(b []byte, c byte) {
+	for i := range b {
+		b[i] = c
+	}
+}
@@ -0,0 +1,51 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package logger
+
+import (
+	"bytes"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mask is written in the logs in place of the secrets.
+const Mask = "****"
+
+/*
+MaskingFormatter wraps a logrus formatter and replaces every occurrence of the
+secrets in the formatted entries, so that credentials never reach the logs
+even when they are part of a URL or an error message.
+*/
+type MaskingFormatter struct {
+	Formatter log.Formatter
+	Secrets   []string
+}
+
+// Format formats the entry with the wrapped formatter and masks the secrets.
+func (f *MaskingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	formatted, err := f.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range f.Secrets {
+		if secret != "" {
+			formatted = bytes.ReplaceAll(formatted, []byte(secret), []byte(Mask))
+		}
+	}
+	return formatted, nil
+}
@@ -0,0 +1,144 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package network
+
+import (
+	"csbench/config"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// Subnet is the VLAN and IP range of a shared network.
+type Subnet struct {
+	Vlan    string
+	Gateway string
+	Netmask string
+	StartIp string
+	EndIp   string
+}
+
+/*
+Allocator allocates the VLANs and subnets of the shared networks of a zone:
+the VLANs from the vlanpool, leaving out the reservedvlans, and the subnets of
+subnetprefix bits from the cidrpool. The VLANs and the subnets overlapping the
+CIDR of the existing networks of the zone are not allocated.
+*/
+type Allocator struct {
+	mu       sync.Mutex
+	cfg      *config.Config
+	usedVlan map[int]bool
+	used     []*net.IPNet
+	subnet   uint32
+}
+
+// NewAllocator returns an allocator for the zone of the given networks, all
+// the networks of the zone as listed by ListZoneNetworks.
+func NewAllocator(cfg *config.Config, networks []*cloudstack.Network) *Allocator {
+	a := &Allocator{cfg: cfg, usedVlan: make(map[int]bool)}
+	for _, n := range networks {
+		vlan := n.Vlan
+		if vlan == "" {
+			vlan = strings.TrimPrefix(n.Broadcasturi, "vlan://")
+		}
+		if id, err := strconv.Atoi(vlan); err == nil {
+			a.usedVlan[id] = true
+		}
+		if _, cidr, err := net.ParseCIDR(n.Cidr); err == nil {
+			a.used = append(a.used, cidr)
+		}
+	}
+	return a
+}
+
+// Next allocates the VLAN and subnet of a new network, or returns an error if
+// one of the pools is exhausted.
+func (a *Allocator) Next() (*Subnet, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	vlan, err := a.nextVlan()
+	if err != nil {
+		return nil, err
+	}
+	cidr, err := a.nextSubnet()
+	if err != nil {
+		return nil, err
+	}
+	a.usedVlan[vlan] = true
+	a.used = append(a.used, cidr)
+
+	first := binary.BigEndian.Uint32(cidr.IP.To4())
+	last := first | ^binary.BigEndian.Uint32(cidr.Mask)
+	return &Subnet{
+		Vlan:    strconv.Itoa(vlan),
+		Gateway: ipv4(first + 1).String(),
+		Netmask: net.IP(cidr.Mask).String(),
+		StartIp: ipv4(first + 2).String(),
+		EndIp:   ipv4(last - 1).String(),
+	}, nil
+}
+
+func (a *Allocator) nextVlan() (int, error) {
+	for _, vlanRange := range a.cfg.VlanPool {
+		for vlan := vlanRange.Start; vlan <= vlanRange.End; vlan++ {
+			if !a.usedVlan[vlan] && !a.cfg.ReservedVlans.Contains(vlan) {
+				return vlan, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no free VLAN left in the vlanpool %s", a.cfg.VlanPool)
+}
+
+func (a *Allocator) nextSubnet() (*net.IPNet, error) {
+	_, pool, err := net.ParseCIDR(a.cfg.CidrPool)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidrpool %q", a.cfg.CidrPool)
+	}
+	ones, _ := pool.Mask.Size()
+	count := uint32(1) << (a.cfg.SubnetPrefix - ones)
+	base := binary.BigEndian.Uint32(pool.IP.To4())
+	size := uint32(1) << (32 - a.cfg.SubnetPrefix)
+	for ; a.subnet < count; a.subnet++ {
+		cidr := &net.IPNet{IP: ipv4(base + a.subnet*size), Mask: net.CIDRMask(a.cfg.SubnetPrefix, 32)}
+		if !a.overlaps(cidr) {
+			return cidr, nil
+		}
+	}
+	return nil, fmt.Errorf("no free /%d subnet left in the cidrpool %s", a.cfg.SubnetPrefix, a.cfg.CidrPool)
+}
+
+func (a *Allocator) overlaps(cidr *net.IPNet) bool {
+	for _, used := range a.used {
+		if used.Contains(cidr.IP) || cidr.Contains(used.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipv4(ip uint32) net.IP {
+	b := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(b, ip)
+	return b
+}
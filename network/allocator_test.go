@@ -0,0 +1,110 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package network
+
+import (
+	"csbench/config"
+	"strings"
+	"testing"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+func TestAllocatorVlans(t *testing.T) {
+	cfg := &config.Config{
+		VlanPool:      config.VlanRanges{{Start: 100, End: 105}},
+		ReservedVlans: config.VlanRanges{{Start: 100, End: 101}},
+		CidrPool:      "10.64.0.0/16",
+		SubnetPrefix:  24,
+	}
+	a := NewAllocator(cfg, []*cloudstack.Network{
+		{Vlan: "102"},
+		{Broadcasturi: "vlan://103"},
+		{Vlan: "untagged"},
+	})
+
+	for _, want := range []string{"104", "105"} {
+		subnet, err := a.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subnet.Vlan != want {
+			t.Errorf("VLAN = %s, want %s", subnet.Vlan, want)
+		}
+	}
+	if subnet, err := a.Next(); err == nil || !strings.Contains(err.Error(), "no free VLAN left in the vlanpool 100-105") {
+		t.Errorf("Next() = %+v, %v, want the vlanpool exhausted", subnet, err)
+	}
+}
+
+func TestAllocatorSubnets(t *testing.T) {
+	cfg := &config.Config{
+		VlanPool:     config.VlanRanges{{Start: 80, End: 4094}},
+		CidrPool:     "10.0.0.0/24",
+		SubnetPrefix: 26,
+	}
+	// The existing networks overlap the first subnet of the pool, which holds
+	// their CIDR, and the last two, which their CIDR holds.
+	a := NewAllocator(cfg, []*cloudstack.Network{
+		{Vlan: "80", Cidr: "10.0.0.0/27"},
+		{Vlan: "81", Cidr: "10.0.0.128/25"},
+		{Cidr: "invalid"},
+	})
+
+	subnet, err := a.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Subnet{Vlan: "82", Gateway: "10.0.0.65", Netmask: "255.255.255.192", StartIp: "10.0.0.66", EndIp: "10.0.0.126"}
+	if *subnet != want {
+		t.Errorf("Next() = %+v, want %+v", *subnet, want)
+	}
+	if subnet, err := a.Next(); err == nil || !strings.Contains(err.Error(), "no free /26 subnet left in the cidrpool 10.0.0.0/24") {
+		t.Errorf("Next() = %+v, %v, want the cidrpool exhausted", subnet, err)
+	}
+}
+
+func TestAllocatorSequence(t *testing.T) {
+	cfg := &config.Config{
+		VlanPool:      config.VlanRanges{{Start: 200, End: 201}, {Start: 300, End: 300}},
+		ReservedVlans: config.VlanRanges{{Start: 201, End: 201}},
+		CidrPool:      "192.168.0.0/22",
+		SubnetPrefix:  24,
+	}
+	a := NewAllocator(cfg, nil)
+
+	want := []struct {
+		vlan    string
+		gateway string
+	}{
+		{"200", "192.168.0.1"},
+		{"300", "192.168.1.1"},
+	}
+	for _, w := range want {
+		subnet, err := a.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subnet.Vlan != w.vlan || subnet.Gateway != w.gateway {
+			t.Errorf("Next() = VLAN %s gateway %s, want VLAN %s gateway %s", subnet.Vlan, subnet.Gateway, w.vlan, w.gateway)
+		}
+	}
+	if _, err := a.Next(); err == nil {
+		t.Error("Next() succeeded, want the vlanpool exhausted")
+	}
+}
@@ -21,37 +21,84 @@ import (
 	"csbench/config"
 	"csbench/utils"
 	"log"
-	"strconv"
 
 	"github.com/apache/cloudstack-go/v2/cloudstack"
 )
 
-func ListNetworks(cs *cloudstack.CloudStackClient, domainId string) ([]*cloudstack.Network, error) {
-	p := cs.Network.NewListNetworksParams()
-	p.SetDomainid(domainId)
-	p.SetListall(true)
-	p.SetZoneid(config.ZoneId)
-	p.SetPage(1)
-	p.SetPagesize(config.PageSize)
-	resp, err := cs.Network.ListNetworks(p)
+// ListNetworks lists the networks of the domain in each of the configured zones,
+// or in all the zones if none is configured, going through all the pages.
+func ListNetworks(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.Network, error) {
+	zoneIds := cfg.ZoneIds
+	if len(zoneIds) == 0 {
+		zoneIds = []string{""}
+	}
+
+	var networks []*cloudstack.Network
+	for _, zoneId := range zoneIds {
+		zoneNetworks, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Network, int, error) {
+			p := cs.Network.NewListNetworksParams()
+			p.SetDomainid(domainId)
+			p.SetListall(true)
+			if zoneId != "" {
+				p.SetZoneid(zoneId)
+			}
+			p.SetPage(page)
+			p.SetPagesize(pageSize)
+			resp, err := cs.Network.ListNetworks(p)
+			if err != nil {
+				return nil, 0, err
+			}
+			return resp.Networks, resp.Count, nil
+		})
+		if err != nil {
+			log.Printf("Failed to list networks due to %v", err)
+			return nil, err
+		}
+		networks = append(networks, zoneNetworks...)
+	}
+	return networks, nil
+}
+
+// ListZoneNetworks lists all the networks of the zone, of every domain and
+// account, going through all the pages.
+func ListZoneNetworks(cs *cloudstack.CloudStackClient, cfg *config.Config, zoneId string) ([]*cloudstack.Network, error) {
+	networks, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Network, int, error) {
+		p := cs.Network.NewListNetworksParams()
+		p.SetZoneid(zoneId)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Network.ListNetworks(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Networks, resp.Count, nil
+	})
 	if err != nil {
-		log.Printf("Failed to list networks due to %v", err)
+		log.Printf("Failed to list the networks of zone %s due to %v", zoneId, err)
 		return nil, err
 	}
-	return resp.Networks, nil
+	return networks, nil
 }
 
-func CreateNetwork(cs *cloudstack.CloudStackClient, domainId string, count int) (*cloudstack.CreateNetworkResponse, error) {
-	netName := "Network-" + utils.RandomString(10)
-	p := cs.Network.NewCreateNetworkParams(netName, config.NetworkOfferingId, config.ZoneId)
+// CreateNetwork creates a shared network in the domain with the VLAN and IP
+// range of the subnet, owned by the account if one is given.
+func CreateNetwork(cs *cloudstack.CloudStackClient, cfg *config.Config, zoneId string, domainId string, account string, subnet *Subnet) (*cloudstack.CreateNetworkResponse, error) {
+	netName := cfg.ResourceName(config.KindNetwork)
+	p := cs.Network.NewCreateNetworkParams(netName, cfg.NetworkOfferingId, zoneId)
 	p.SetDomainid(domainId)
-	p.SetAcltype("Domain")
-	p.SetGateway("10.10.0.1")
-	p.SetNetmask("255.255.252.0")
+	if account != "" {
+		p.SetAcltype("Account")
+		p.SetAccount(account)
+	} else {
+		p.SetAcltype("Domain")
+	}
+	p.SetGateway(subnet.Gateway)
+	p.SetNetmask(subnet.Netmask)
 	p.SetDisplaytext(netName)
-	p.SetStartip("10.10.0.2")
-	p.SetEndip("10.10.3.255")
-	p.SetVlan(strconv.Itoa(80 + count))
+	p.SetStartip(subnet.StartIp)
+	p.SetEndip(subnet.EndIp)
+	p.SetVlan(subnet.Vlan)
 
 	resp, err := cs.Network.CreateNetwork(p)
 	if err != nil {
@@ -61,6 +108,25 @@ func CreateNetwork(cs *cloudstack.CloudStackClient, domainId string, count int)
 	return resp, nil
 }
 
+// CreateIsolatedNetwork creates an isolated network owned by the account, with
+// the guest CIDR and VLAN picked by CloudStack. Its virtual router is deployed
+// when the network is implemented, i.e. when it is created if the offering is
+// persistent, and otherwise along with its first VM.
+func CreateIsolatedNetwork(cs *cloudstack.CloudStackClient, cfg *config.Config, zoneId string, domainId string, account string) (*cloudstack.CreateNetworkResponse, error) {
+	netName := cfg.ResourceName(config.KindIsolated)
+	p := cs.Network.NewCreateNetworkParams(netName, cfg.IsolatedOfferingId, zoneId)
+	p.SetDomainid(domainId)
+	p.SetAccount(account)
+	p.SetDisplaytext(netName)
+
+	resp, err := cs.Network.CreateNetwork(p)
+	if err != nil {
+		log.Printf("Failed to create isolated network due to: %v", err)
+		return nil, err
+	}
+	return resp, nil
+}
+
 func DeleteNetwork(cs *cloudstack.CloudStackClient, networkId string) (bool, error) {
 	deleteParams := cs.Network.NewDeleteNetworkParams(networkId)
 	delResp, err := cs.Network.DeleteNetwork(deleteParams)
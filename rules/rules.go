@@ -0,0 +1,216 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package rules
+
+import (
+	"csbench/config"
+	"csbench/utils"
+	"log"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// The rules are TCP rules of a single port open to any source, the port
+// forwarding and load balancer rules forwarding to the same port of the VMs.
+const (
+	protocol    = "tcp"
+	anyCidr     = "0.0.0.0/0"
+	lbAlgorithm = "roundrobin"
+)
+
+// ListFirewallRules lists the ingress firewall rules of the public IP
+// addresses of the domain, going through all the pages.
+func ListFirewallRules(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.FirewallRule, error) {
+	rules, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.FirewallRule, int, error) {
+		p := cs.Firewall.NewListFirewallRulesParams()
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Firewall.ListFirewallRules(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.FirewallRules, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list firewall rules due to %v", err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ListEgressRules lists the egress firewall rules of the networks of the
+// domain, going through all the pages.
+func ListEgressRules(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.EgressFirewallRule, error) {
+	rules, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.EgressFirewallRule, int, error) {
+		p := cs.Firewall.NewListEgressFirewallRulesParams()
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Firewall.ListEgressFirewallRules(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.EgressFirewallRules, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list egress firewall rules due to %v", err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ListPortForwardingRules lists the port forwarding rules of the public IP
+// addresses of the domain, going through all the pages.
+func ListPortForwardingRules(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.PortForwardingRule, error) {
+	rules, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.PortForwardingRule, int, error) {
+		p := cs.Firewall.NewListPortForwardingRulesParams()
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Firewall.ListPortForwardingRules(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.PortForwardingRules, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list port forwarding rules due to %v", err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ListLoadBalancerRules lists the load balancer rules of the public IP
+// addresses of the domain, going through all the pages.
+func ListLoadBalancerRules(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.LoadBalancerRule, error) {
+	rules, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.LoadBalancerRule, int, error) {
+		p := cs.LoadBalancer.NewListLoadBalancerRulesParams()
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.LoadBalancer.ListLoadBalancerRules(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.LoadBalancerRules, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list load balancer rules due to %v", err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ListLoadBalancerVms returns the IDs of the VMs assigned to the load balancer
+// rule, going through all the pages.
+func ListLoadBalancerVms(cs *cloudstack.CloudStackClient, cfg *config.Config, ruleId string) ([]string, error) {
+	vms, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.VirtualMachine, int, error) {
+		p := cs.LoadBalancer.NewListLoadBalancerRuleInstancesParams(ruleId)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.LoadBalancer.ListLoadBalancerRuleInstances(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.LoadBalancerRuleInstances, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list the VMs of load balancer rule %s due to %v", ruleId, err)
+		return nil, err
+	}
+	vmIds := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		vmIds = append(vmIds, vm.Id)
+	}
+	return vmIds, nil
+}
+
+// CreateFirewallRule opens the port of the public IP address.
+func CreateFirewallRule(cs *cloudstack.CloudStackClient, ipId string, port int) (*cloudstack.CreateFirewallRuleResponse, error) {
+	p := cs.Firewall.NewCreateFirewallRuleParams(ipId, protocol)
+	p.SetStartport(port)
+	p.SetEndport(port)
+	p.SetCidrlist([]string{anyCidr})
+	resp, err := cs.Firewall.CreateFirewallRule(p)
+	if err != nil {
+		log.Printf("Failed to create a firewall rule for port %d of public IP address %s due to: %v", port, ipId, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CreateEgressRule allows the traffic of the network to the port.
+func CreateEgressRule(cs *cloudstack.CloudStackClient, networkId string, port int) (*cloudstack.CreateEgressFirewallRuleResponse, error) {
+	p := cs.Firewall.NewCreateEgressFirewallRuleParams(networkId, protocol)
+	p.SetStartport(port)
+	p.SetEndport(port)
+	p.SetDestcidrlist([]string{anyCidr})
+	resp, err := cs.Firewall.CreateEgressFirewallRule(p)
+	if err != nil {
+		log.Printf("Failed to create an egress firewall rule for port %d of network %s due to: %v", port, networkId, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CreatePortForwardingRule forwards the port of the public IP address to the
+// same port of the VM. The port is not opened in the firewall.
+func CreatePortForwardingRule(cs *cloudstack.CloudStackClient, ipId string, port int, vmId string) (*cloudstack.CreatePortForwardingRuleResponse, error) {
+	p := cs.Firewall.NewCreatePortForwardingRuleParams(ipId, port, protocol, port, vmId)
+	p.SetOpenfirewall(false)
+	resp, err := cs.Firewall.CreatePortForwardingRule(p)
+	if err != nil {
+		log.Printf("Failed to create a port forwarding rule for port %d of public IP address %s due to: %v", port, ipId, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CreateLoadBalancerRule balances the port of the public IP address of the
+// network across the same port of the VMs assigned to the rule. The port is
+// not opened in the firewall.
+func CreateLoadBalancerRule(cs *cloudstack.CloudStackClient, cfg *config.Config, ipId string, networkId string, port int) (*cloudstack.CreateLoadBalancerRuleResponse, error) {
+	p := cs.LoadBalancer.NewCreateLoadBalancerRuleParams(lbAlgorithm, cfg.ResourceName(config.KindLoadBalancer), port, port)
+	p.SetPublicipid(ipId)
+	p.SetNetworkid(networkId)
+	p.SetProtocol(protocol)
+	p.SetOpenfirewall(false)
+	resp, err := cs.LoadBalancer.CreateLoadBalancerRule(p)
+	if err != nil {
+		log.Printf("Failed to create a load balancer rule for port %d of public IP address %s due to: %v", port, ipId, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// AssignToLoadBalancerRule adds the VMs to the load balancer rule.
+func AssignToLoadBalancerRule(cs *cloudstack.CloudStackClient, ruleId string, vmIds []string) (bool, error) {
+	p := cs.LoadBalancer.NewAssignToLoadBalancerRuleParams(ruleId)
+	p.SetVirtualmachineids(vmIds)
+	resp, err := cs.LoadBalancer.AssignToLoadBalancerRule(p)
+	if err != nil {
+		log.Printf("Failed to assign %d VMs to load balancer rule %s due to: %v", len(vmIds), ruleId, err)
+		return false, err
+	}
+	return resp.Success, nil
+}
@@ -0,0 +1,160 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package snapshot
+
+import (
+	"csbench/config"
+	"csbench/utils"
+	"log"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// policySchedules are the schedules of the snapshot policies created for
+// each interval type, at midnight UTC, on Mondays for weekly and on the first
+// of the month for monthly policies.
+var policySchedules = map[string]string{
+	"hourly":  "00",
+	"daily":   "00:00",
+	"weekly":  "00:00:2",
+	"monthly": "00:00:1",
+}
+
+// ListSnapshots lists the volume snapshots of the domain, going through all
+// the pages.
+func ListSnapshots(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.Snapshot, error) {
+	snapshots, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Snapshot, int, error) {
+		p := cs.Snapshot.NewListSnapshotsParams()
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Snapshot.ListSnapshots(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Snapshots, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list snapshots due to %v", err)
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// ListVmSnapshots lists the VM snapshots of the domain, going through all the
+// pages.
+func ListVmSnapshots(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.VMSnapshot, error) {
+	snapshots, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.VMSnapshot, int, error) {
+		p := cs.Snapshot.NewListVMSnapshotParams()
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Snapshot.ListVMSnapshot(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.VMSnapshot, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list VM snapshots due to %v", err)
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// ListSnapshotPolicies lists the snapshot policies of the volume, going
+// through all the pages.
+func ListSnapshotPolicies(cs *cloudstack.CloudStackClient, cfg *config.Config, volumeId string) ([]*cloudstack.SnapshotPolicy, error) {
+	policies, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.SnapshotPolicy, int, error) {
+		p := cs.Snapshot.NewListSnapshotPoliciesParams()
+		p.SetVolumeid(volumeId)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Snapshot.ListSnapshotPolicies(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.SnapshotPolicies, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list the snapshot policies of volume %s due to %v", volumeId, err)
+		return nil, err
+	}
+	return policies, nil
+}
+
+// CreateSnapshot takes a snapshot of the volume.
+func CreateSnapshot(cs *cloudstack.CloudStackClient, cfg *config.Config, volumeId string) (*cloudstack.CreateSnapshotResponse, error) {
+	p := cs.Snapshot.NewCreateSnapshotParams(volumeId)
+	p.SetName(cfg.ResourceName(config.KindSnapshot))
+	resp, err := cs.Snapshot.CreateSnapshot(p)
+	if err != nil {
+		log.Printf("Failed to snapshot volume %s due to: %v", volumeId, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CreateVmSnapshot takes a snapshot of the disks of the VM, without its memory.
+func CreateVmSnapshot(cs *cloudstack.CloudStackClient, cfg *config.Config, vmId string) (*cloudstack.CreateVMSnapshotResponse, error) {
+	p := cs.Snapshot.NewCreateVMSnapshotParams(vmId)
+	p.SetName(cfg.ResourceName(config.KindVmSnapshot))
+	p.SetSnapshotmemory(false)
+	resp, err := cs.Snapshot.CreateVMSnapshot(p)
+	if err != nil {
+		log.Printf("Failed to snapshot VM %s due to: %v", vmId, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CreateSnapshotPolicy schedules snapshots of the volume with the snapshotpolicy
+// interval type, keeping up to maxSnaps of them.
+func CreateSnapshotPolicy(cs *cloudstack.CloudStackClient, cfg *config.Config, volumeId string, maxSnaps int) (*cloudstack.CreateSnapshotPolicyResponse, error) {
+	p := cs.Snapshot.NewCreateSnapshotPolicyParams(cfg.SnapshotPolicy, maxSnaps, policySchedules[cfg.SnapshotPolicy], "UTC", volumeId)
+	resp, err := cs.Snapshot.CreateSnapshotPolicy(p)
+	if err != nil {
+		log.Printf("Failed to create a %s snapshot policy for volume %s due to: %v", cfg.SnapshotPolicy, volumeId, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DeleteSnapshot deletes the volume snapshot.
+func DeleteSnapshot(cs *cloudstack.CloudStackClient, snapshotId string) (bool, error) {
+	p := cs.Snapshot.NewDeleteSnapshotParams(snapshotId)
+	resp, err := cs.Snapshot.DeleteSnapshot(p)
+	if err != nil {
+		log.Printf("Failed to delete snapshot with id %s due to %v", snapshotId, err)
+		return false, err
+	}
+	return resp.Success, nil
+}
+
+// DeleteVmSnapshot deletes the VM snapshot.
+func DeleteVmSnapshot(cs *cloudstack.CloudStackClient, vmSnapshotId string) (bool, error) {
+	p := cs.Snapshot.NewDeleteVMSnapshotParams(vmSnapshotId)
+	resp, err := cs.Snapshot.DeleteVMSnapshot(p)
+	if err != nil {
+		log.Printf("Failed to delete VM snapshot with id %s due to %v", vmSnapshotId, err)
+		return false, err
+	}
+	return resp.Success, nil
+}
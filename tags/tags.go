@@ -0,0 +1,77 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tags
+
+import (
+	"csbench/config"
+	"csbench/utils"
+	"log"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// The types of the resources tagged, as named by createTags.
+const (
+	ResourceTypeVm                 = "UserVm"
+	ResourceTypeVolume             = "Volume"
+	ResourceTypeNetwork            = "Network"
+	ResourceTypeVpc                = "Vpc"
+	ResourceTypePublicIp           = "PublicIpAddress"
+	ResourceTypeFirewallRule       = "FirewallRule"
+	ResourceTypePortForwardingRule = "PortForwardingRule"
+	ResourceTypeLoadBalancer       = "LoadBalancer"
+	ResourceTypeSnapshot           = "Snapshot"
+	ResourceTypeVmSnapshot         = "VMSnapshot"
+	ResourceTypeTemplate           = "Template"
+	ResourceTypeIso                = "ISO"
+)
+
+// CreateTags sets the resource tags of the run, see config.ResourceTags, on the resource.
+func CreateTags(cs *cloudstack.CloudStackClient, cfg *config.Config, resourceType string, resourceId string) error {
+	p := cs.Resourcetags.NewCreateTagsParams([]string{resourceId}, resourceType, cfg.ResourceTags())
+	_, err := cs.Resourcetags.CreateTags(p)
+	if err != nil {
+		log.Printf("Failed to tag %s %s due to: %v", resourceType, resourceId, err)
+		return err
+	}
+	return nil
+}
+
+// ListTagged lists the tags with the key and value, across all the domains,
+// going through all the pages. Their Resourceid is the ID of the resource tagged.
+func ListTagged(cs *cloudstack.CloudStackClient, cfg *config.Config, key string, value string) ([]*cloudstack.Tag, error) {
+	tags, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Tag, int, error) {
+		p := cs.Resourcetags.NewListTagsParams()
+		p.SetKey(key)
+		p.SetValue(value)
+		p.SetListall(true)
+		p.SetIsrecursive(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Resourcetags.ListTags(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Tags, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list tags due to %v", err)
+		return nil, err
+	}
+	return tags, nil
+}
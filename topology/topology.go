@@ -0,0 +1,298 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+/*
+Package topology describes the environment created by csbench -create
+-topology, as a JSON file like:
+
+	{
+	  "domains": [
+	    {
+	      "count": 2,
+	      "accounts": [
+	        {
+	          "count": 2,
+	          "type": "user",
+	          "users": 3,
+	          "networks": [
+	            {
+	              "count": 1,
+	              "vms": [
+	                {"count": 4, "serviceoffering": "Small Instance", "volumes": 1},
+	                {"count": 1, "serviceoffering": "Medium Instance", "volumes": 2, "diskoffering": "Medium"}
+	              ]
+	            }
+	          ]
+	        }
+	      ],
+	      "subdomains": [
+	        {"count": 3, "accounts": [{"type": "domainadmin"}]}
+	      ]
+	    }
+	  ]
+	}
+
+Every count defaults to 1, and must be at least 1 when set. The domains are
+created under the parentdomainid, and the subdomains under each domain of their
+parent. The accounts are domain admins by default and users is the total number
+of users of the account, including the one created with it. The networks are
+shared networks owned by the account and spread across the zones. The offerings
+are names, and default to the serviceofferingid and diskofferingid of the
+configuration.
+*/
+package topology
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Account types.
+const (
+	AccountTypeUser        = "user"
+	AccountTypeDomainAdmin = "domainadmin"
+)
+
+type Topology struct {
+	Domains []*Domain `json:"domains"`
+}
+
+type Domain struct {
+	Count      int        `json:"count"`
+	Accounts   []*Account `json:"accounts"`
+	Subdomains []*Domain  `json:"subdomains"`
+}
+
+type Account struct {
+	Count    int        `json:"count"`
+	Type     string     `json:"type"`
+	Users    int        `json:"users"`
+	Networks []*Network `json:"networks"`
+}
+
+type Network struct {
+	Count int        `json:"count"`
+	Vms   []*VmGroup `json:"vms"`
+}
+
+// VmGroup is a number of identical VMs in a network.
+type VmGroup struct {
+	Count           int    `json:"count"`
+	ServiceOffering string `json:"serviceoffering"`
+	Volumes         int    `json:"volumes"`
+	DiskOffering    string `json:"diskoffering"`
+}
+
+// Totals is the number of resources of each type in a topology.
+type Totals struct {
+	Domains  int
+	Accounts int
+	Users    int
+	Networks int
+	Vms      int
+	Volumes  int
+}
+
+// ReadFile reads and validates the topology file at path. Unknown keys are errors.
+func ReadFile(path string) (*Topology, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+	topology := &Topology{}
+	if err := decodeStrict(content, topology); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := topology.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return topology, nil
+}
+
+// UnmarshalJSON decodes the domain over its defaults, so that a count which is
+// left out is 1 while an explicit 0 is kept for Validate to reject.
+func (d *Domain) UnmarshalJSON(data []byte) error {
+	type domain Domain
+	v := domain{Count: 1}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	*d = Domain(v)
+	return nil
+}
+
+// UnmarshalJSON decodes the account over its defaults, a single domain admin
+// account with a single user.
+func (a *Account) UnmarshalJSON(data []byte) error {
+	type account Account
+	v := account{Count: 1, Type: AccountTypeDomainAdmin, Users: 1}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	*a = Account(v)
+	return nil
+}
+
+// UnmarshalJSON decodes the network over its default count.
+func (n *Network) UnmarshalJSON(data []byte) error {
+	type network Network
+	v := network{Count: 1}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	*n = Network(v)
+	return nil
+}
+
+// UnmarshalJSON decodes the VM group over its default count.
+func (g *VmGroup) UnmarshalJSON(data []byte) error {
+	type vmGroup VmGroup
+	v := vmGroup{Count: 1}
+	if err := decodeStrict(data, &v); err != nil {
+		return err
+	}
+	*g = VmGroup(v)
+	return nil
+}
+
+// decodeStrict decodes data into v, rejecting the unknown keys.
+func decodeStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// Validate checks the counts and account types, and reports every problem
+// with the path of the offending entry, e.g. domains[0].accounts[1].
+func (t *Topology) Validate() error {
+	var errs []error
+	fail := func(path string, format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, a...)))
+	}
+	positive := func(path string, key string, value int) {
+		if value < 1 {
+			fail(path, "%s must be at least 1, got %d", key, value)
+		}
+	}
+
+	if len(t.Domains) == 0 {
+		errs = append(errs, fmt.Errorf("no domains are defined"))
+	}
+	var validateDomain func(path string, d *Domain)
+	validateDomain = func(path string, d *Domain) {
+		positive(path, "count", d.Count)
+		for i, a := range d.Accounts {
+			accountPath := fmt.Sprintf("%s.accounts[%d]", path, i)
+			positive(accountPath, "count", a.Count)
+			positive(accountPath, "users", a.Users)
+			if a.Type != AccountTypeUser && a.Type != AccountTypeDomainAdmin {
+				fail(accountPath, "type must be %s or %s, got %q", AccountTypeUser, AccountTypeDomainAdmin, a.Type)
+			}
+			for j, n := range a.Networks {
+				networkPath := fmt.Sprintf("%s.networks[%d]", accountPath, j)
+				positive(networkPath, "count", n.Count)
+				for k, g := range n.Vms {
+					vmPath := fmt.Sprintf("%s.vms[%d]", networkPath, k)
+					positive(vmPath, "count", g.Count)
+					if g.Volumes < 0 {
+						fail(vmPath, "volumes must not be negative, got %d", g.Volumes)
+					}
+				}
+			}
+		}
+		for i, s := range d.Subdomains {
+			validateDomain(fmt.Sprintf("%s.subdomains[%d]", path, i), s)
+		}
+	}
+	for i, d := range t.Domains {
+		validateDomain(fmt.Sprintf("domains[%d]", i), d)
+	}
+	return errors.Join(errs...)
+}
+
+// Totals returns the number of resources of each type described by the topology.
+func (t *Topology) Totals() Totals {
+	var totals Totals
+	var countDomain func(d *Domain, parents int)
+	countDomain = func(d *Domain, parents int) {
+		domains := parents * d.Count
+		totals.Domains += domains
+		for _, a := range d.Accounts {
+			accounts := domains * a.Count
+			totals.Accounts += accounts
+			totals.Users += accounts * a.Users
+			for _, n := range a.Networks {
+				networks := accounts * n.Count
+				totals.Networks += networks
+				for _, g := range n.Vms {
+					totals.Vms += networks * g.Count
+					totals.Volumes += networks * g.Count * g.Volumes
+				}
+			}
+		}
+		for _, s := range d.Subdomains {
+			countDomain(s, domains)
+		}
+	}
+	for _, d := range t.Domains {
+		countDomain(d, 1)
+	}
+	return totals
+}
+
+// ServiceOfferings returns the distinct service offering names used by the VMs.
+func (t *Topology) ServiceOfferings() []string {
+	return t.offerings(func(g *VmGroup) string { return g.ServiceOffering })
+}
+
+// DiskOfferings returns the distinct disk offering names used by the volumes.
+func (t *Topology) DiskOfferings() []string {
+	return t.offerings(func(g *VmGroup) string {
+		if g.Volumes == 0 {
+			return ""
+		}
+		return g.DiskOffering
+	})
+}
+
+func (t *Topology) offerings(name func(g *VmGroup) string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	var visit func(d *Domain)
+	visit = func(d *Domain) {
+		for _, a := range d.Accounts {
+			for _, n := range a.Networks {
+				for _, g := range n.Vms {
+					if offering := name(g); offering != "" && !seen[offering] {
+						seen[offering] = true
+						names = append(names, offering)
+					}
+				}
+			}
+		}
+		for _, s := range d.Subdomains {
+			visit(s)
+		}
+	}
+	for _, d := range t.Domains {
+		visit(d)
+	}
+	return names
+}
@@ -0,0 +1,152 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package topology
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readString reads a topology from its JSON, like ReadFile.
+func readString(t *testing.T, content string) (*Topology, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "topology.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return ReadFile(path)
+}
+
+func TestReadFileDefaults(t *testing.T) {
+	topology, err := readString(t, `{
+	  "domains": [
+	    {
+	      "accounts": [{"networks": [{"vms": [{"volumes": 2}]}]}],
+	      "subdomains": [{"count": 3, "accounts": [{"type": "user", "users": 2}]}]
+	    }
+	  ]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := topology.Domains[0]
+	a := d.Accounts[0]
+	n := a.Networks[0]
+	g := n.Vms[0]
+	if d.Count != 1 || a.Count != 1 || n.Count != 1 || g.Count != 1 {
+		t.Errorf("counts = %d, %d, %d, %d, want 1 when omitted", d.Count, a.Count, n.Count, g.Count)
+	}
+	if a.Type != AccountTypeDomainAdmin || a.Users != 1 {
+		t.Errorf("account = %+v, want a domain admin with 1 user", a)
+	}
+
+	want := Totals{Domains: 4, Accounts: 4, Users: 7, Networks: 1, Vms: 1, Volumes: 2}
+	if got := topology.Totals(); got != want {
+		t.Errorf("Totals() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "no domains",
+			content: `{"domains": []}`,
+			want:    []string{"no domains are defined"},
+		},
+		{
+			name:    "explicit zero domain count",
+			content: `{"domains": [{"count": 0}]}`,
+			want:    []string{"domains[0]: count must be at least 1, got 0"},
+		},
+		{
+			name:    "explicit zero account counts",
+			content: `{"domains": [{"accounts": [{}, {"count": 0, "users": 0}]}]}`,
+			want: []string{
+				"domains[0].accounts[1]: count must be at least 1, got 0",
+				"domains[0].accounts[1]: users must be at least 1, got 0",
+			},
+		},
+		{
+			name:    "explicit zero network and VM counts",
+			content: `{"domains": [{"accounts": [{"networks": [{"count": 0, "vms": [{"count": 0, "volumes": -1}]}]}]}]}`,
+			want: []string{
+				"domains[0].accounts[0].networks[0]: count must be at least 1, got 0",
+				"domains[0].accounts[0].networks[0].vms[0]: count must be at least 1, got 0",
+				"domains[0].accounts[0].networks[0].vms[0]: volumes must not be negative, got -1",
+			},
+		},
+		{
+			name:    "explicit zero subdomain count",
+			content: `{"domains": [{"subdomains": [{"count": 0}]}]}`,
+			want:    []string{"domains[0].subdomains[0]: count must be at least 1, got 0"},
+		},
+		{
+			name:    "account type",
+			content: `{"domains": [{"accounts": [{"type": "admin"}]}]}`,
+			want:    []string{`domains[0].accounts[0]: type must be user or domainadmin, got "admin"`},
+		},
+		{
+			name:    "unknown key",
+			content: `{"domains": [{"accounts": [{"user": 2}]}]}`,
+			want:    []string{`unknown field "user"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topology, err := readString(t, tt.content)
+			if err == nil {
+				t.Fatalf("ReadFile returned %+v, want an error", topology)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOfferings(t *testing.T) {
+	topology, err := readString(t, `{
+	  "domains": [
+	    {
+	      "accounts": [{"networks": [{"vms": [
+	        {"serviceoffering": "Small", "volumes": 0, "diskoffering": "Unused"},
+	        {"serviceoffering": "Medium", "volumes": 1, "diskoffering": "Large"}
+	      ]}]}],
+	      "subdomains": [{"accounts": [{"networks": [{"vms": [{"serviceoffering": "Small"}]}]}]}]
+	    }
+	  ]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(topology.ServiceOfferings(), ","); got != "Small,Medium" {
+		t.Errorf("ServiceOfferings() = %s, want Small,Medium", got)
+	}
+	if got := strings.Join(topology.DiskOfferings(), ","); got != "Large" {
+		t.Errorf("DiskOfferings() = %s, want Large", got)
+	}
+}
@@ -0,0 +1,74 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// contextTransport attaches a context to every request so that in-flight
+// requests are aborted once the context is done.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// NewAsyncClient returns a CloudStack client, configured like the one from
+// cloudstack.NewAsyncClient without SSL verification, whose requests are
+// aborted once ctx is done.
+func NewAsyncClient(ctx context.Context, apiURL string, apiKey string, secretKey string) *cloudstack.CloudStackClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	client := &http.Client{
+		Transport: &contextTransport{ctx: ctx, base: transport},
+		Timeout:   60 * time.Second,
+	}
+	return cloudstack.NewAsyncClient(apiURL, apiKey, secretKey, false, cloudstack.WithHTTPClient(client))
+}
+
+/*
+WithGracePeriod returns a context that is done grace after ctx is done, or
+when the returned cancel function is called. It lets in-flight work finish
+within a deadline after ctx has been cancelled, while no new work is started.
+*/
+func WithGracePeriod(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+			timer := time.NewTimer(grace)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				cancel()
+			case <-graceCtx.Done():
+			}
+		case <-graceCtx.Done():
+		}
+	}()
+	return graceCtx, cancel
+}
@@ -0,0 +1,45 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+// DefaultPageSize is the page size used to list all the resources when the
+// configuration has no pagesize.
+const DefaultPageSize = 500
+
+/*
+ListAllPages calls list for pages of pageSize items, starting with page 1,
+until it has listed count items, the total reported by the API, or a page is
+empty, and returns the items of all the pages. A pageSize of 0 or less uses
+the DefaultPageSize. The items listed so far are returned along with an error.
+*/
+func ListAllPages[T any](pageSize int, list func(page int, pageSize int) (items []T, count int, err error)) ([]T, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	var all []T
+	for page := 1; ; page++ {
+		items, count, err := list(page, pageSize)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, items...)
+		if len(items) == 0 || len(all) >= count {
+			return all, nil
+		}
+	}
+}
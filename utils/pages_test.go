@@ -0,0 +1,83 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestListAllPages(t *testing.T) {
+	errList := errors.New("list failed")
+	tests := []struct {
+		name     string
+		pageSize int
+		// total is the number of items listed, and count the total reported by the API.
+		total     int
+		count     int
+		failPage  int
+		wantItems int
+		wantPages int
+		wantSize  int
+		wantErr   error
+	}{
+		{name: "single page", pageSize: 10, total: 7, count: 7, wantItems: 7, wantPages: 1, wantSize: 10},
+		{name: "exact pages", pageSize: 5, total: 10, count: 10, wantItems: 10, wantPages: 2, wantSize: 5},
+		{name: "partial last page", pageSize: 4, total: 10, count: 10, wantItems: 10, wantPages: 3, wantSize: 4},
+		{name: "no items", pageSize: 4, total: 0, count: 0, wantItems: 0, wantPages: 1, wantSize: 4},
+		{name: "count too high", pageSize: 4, total: 6, count: 100, wantItems: 6, wantPages: 3, wantSize: 4},
+		{name: "default page size", pageSize: 0, total: 600, count: 600, wantItems: 600, wantPages: 2, wantSize: DefaultPageSize},
+		{name: "error", pageSize: 4, total: 10, count: 10, failPage: 2, wantItems: 4, wantPages: 2, wantSize: 4, wantErr: errList},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := 0
+			items, err := ListAllPages(tt.pageSize, func(page int, pageSize int) ([]int, int, error) {
+				pages++
+				if page != pages {
+					t.Fatalf("listed page %d, want %d", page, pages)
+				}
+				if pageSize != tt.wantSize {
+					t.Errorf("page size = %d, want %d", pageSize, tt.wantSize)
+				}
+				if page == tt.failPage {
+					return nil, 0, errList
+				}
+				var items []int
+				for i := (page - 1) * pageSize; i < page*pageSize && i < tt.total; i++ {
+					items = append(items, i)
+				}
+				return items, tt.count, nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if len(items) != tt.wantItems {
+				t.Errorf("listed %d items, want %d", len(items), tt.wantItems)
+			}
+			if pages != tt.wantPages {
+				t.Errorf("listed %d pages, want %d", pages, tt.wantPages)
+			}
+			for i, item := range items {
+				if item != i {
+					t.Fatalf("item %d = %d, want the items in order", i, item)
+				}
+			}
+		})
+	}
+}
@@ -20,31 +20,40 @@ package vm
 import (
 	"csbench/config"
 	"csbench/utils"
-	"encoding/json"
-	"fmt"
 	"log"
 
 	"github.com/apache/cloudstack-go/v2/cloudstack"
 )
 
-func ListVMs(cs *cloudstack.CloudStackClient, domainId string) ([]*cloudstack.VirtualMachine, error) {
-	p := cs.VirtualMachine.NewListVirtualMachinesParams()
-	p.SetDomainid(domainId)
-	p.SetPage(1)
-	p.SetPagesize(config.PageSize)
-	resp, err := cs.VirtualMachine.ListVirtualMachines(p)
+// ListVMs lists the VMs of the domain, going through all the pages.
+func ListVMs(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.VirtualMachine, error) {
+	vms, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.VirtualMachine, int, error) {
+		p := cs.VirtualMachine.NewListVirtualMachinesParams()
+		p.SetDomainid(domainId)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.VirtualMachine.ListVirtualMachines(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.VirtualMachines, resp.Count, nil
+	})
 	if err != nil {
 		log.Printf("Failed to list vm due to %v", err)
 		return nil, err
 	}
-	return resp.VirtualMachines, nil
+	return vms, nil
+}
+
+func DeployVm(cs *cloudstack.CloudStackClient, cfg *config.Config, zoneId string, domainId string, networkId string, account string) (*cloudstack.DeployVirtualMachineResponse, error) {
+	return DeployVmWithOffering(cs, cfg, cfg.ServiceOfferingId, zoneId, domainId, networkId, account)
 }
 
-func DeployVm(cs *cloudstack.CloudStackClient, domainId string, networkId string, account string) (*cloudstack.DeployVirtualMachineResponse, error) {
-	vmName := "Vm-" + utils.RandomString(10)
-	p := cs.VirtualMachine.NewDeployVirtualMachineParams(config.ServiceOfferingId, config.TemplateId, vmName)
+func DeployVmWithOffering(cs *cloudstack.CloudStackClient, cfg *config.Config, serviceOfferingId string, zoneId string, domainId string, networkId string, account string) (*cloudstack.DeployVirtualMachineResponse, error) {
+	vmName := cfg.ResourceName(config.KindVm)
+	p := cs.VirtualMachine.NewDeployVirtualMachineParams(serviceOfferingId, cfg.TemplateId, vmName)
 	p.SetDomainid(domainId)
-	p.SetZoneid(config.ZoneId)
+	p.SetZoneid(zoneId)
 	p.SetNetworkids([]string{networkId})
 	p.SetName(vmName)
 	p.SetAccount(account)
@@ -57,27 +66,13 @@ func DeployVm(cs *cloudstack.CloudStackClient, domainId string, networkId string
 	return resp, nil
 }
 
-func DestroyVm(cs *cloudstack.CloudStackClient, vmId string) {
-
+func DestroyVm(cs *cloudstack.CloudStackClient, vmId string) (*cloudstack.DestroyVirtualMachineResponse, error) {
 	deleteParams := cs.VirtualMachine.NewDestroyVirtualMachineParams(vmId)
 	deleteParams.SetExpunge(true)
 	delResp, err := cs.VirtualMachine.DestroyVirtualMachine(deleteParams)
 	if err != nil {
 		log.Printf("Failed to destroy Vm with Id %s due to %v", vmId, err)
-	}
-	r, err := parseResponse(delResp)
-	if err != nil {
-		log.Printf("Failed to parse destroy vm response due to %v", err)
-		return
-	}
-	fmt.Printf("Destroy Vm response: %v\n\n", string(r))
-}
-
-func parseResponse(resp interface{}) ([]byte, error) {
-	b, err := json.MarshalIndent(resp, "", "    ")
-	if err != nil {
-		log.Printf("%v", err)
 		return nil, err
 	}
-	return b, nil
+	return delResp, nil
 }
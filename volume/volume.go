@@ -26,13 +26,17 @@ import (
 	"csbench/utils"
 )
 
-func CreateVolume(cs *cloudstack.CloudStackClient, domainId string, account string) (*cloudstack.CreateVolumeResponse, error) {
-	volName := "Volume-" + utils.RandomString(10)
+func CreateVolume(cs *cloudstack.CloudStackClient, cfg *config.Config, zoneId string, domainId string, account string) (*cloudstack.CreateVolumeResponse, error) {
+	return CreateVolumeWithOffering(cs, cfg, cfg.DiskOfferingId, zoneId, domainId, account)
+}
+
+func CreateVolumeWithOffering(cs *cloudstack.CloudStackClient, cfg *config.Config, diskOfferingId string, zoneId string, domainId string, account string) (*cloudstack.CreateVolumeResponse, error) {
+	volName := cfg.ResourceName(config.KindVolume)
 	p := cs.Volume.NewCreateVolumeParams()
 	p.SetDomainid(domainId)
 	p.SetName(volName)
-	p.SetZoneid(config.ZoneId)
-	p.SetDiskofferingid(config.DiskOfferingId)
+	p.SetZoneid(zoneId)
+	p.SetDiskofferingid(diskOfferingId)
 	p.SetAccount(account)
 	resp, err := cs.Volume.CreateVolume(p)
 
@@ -43,6 +47,49 @@ func CreateVolume(cs *cloudstack.CloudStackClient, domainId string, account stri
 	return resp, nil
 }
 
+// ListDataVolumes lists the data disks of the domain, going through all the pages.
+func ListDataVolumes(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.Volume, error) {
+	volumes, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Volume, int, error) {
+		p := cs.Volume.NewListVolumesParams()
+		p.SetDomainid(domainId)
+		p.SetType("DATADISK")
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Volume.ListVolumes(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Volumes, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list volumes due to %v", err)
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// ListVolumes lists the root and data disks of the domain, going through all the pages.
+func ListVolumes(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.Volume, error) {
+	volumes, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.Volume, int, error) {
+		p := cs.Volume.NewListVolumesParams()
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.Volume.ListVolumes(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Volumes, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list volumes due to %v", err)
+		return nil, err
+	}
+	return volumes, nil
+}
+
 func DestroyVolume(cs *cloudstack.CloudStackClient, volumeId string) (*cloudstack.DestroyVolumeResponse, error) {
 
 	p := cs.Volume.NewDestroyVolumeParams(volumeId)
@@ -0,0 +1,191 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package vpc
+
+import (
+	"csbench/config"
+	"csbench/utils"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// ListVpcs lists the VPCs of the domain, going through all the pages.
+func ListVpcs(cs *cloudstack.CloudStackClient, cfg *config.Config, domainId string) ([]*cloudstack.VPC, error) {
+	vpcs, err := utils.ListAllPages(cfg.PageSize, func(page int, pageSize int) ([]*cloudstack.VPC, int, error) {
+		p := cs.VPC.NewListVPCsParams()
+		p.SetDomainid(domainId)
+		p.SetListall(true)
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		resp, err := cs.VPC.ListVPCs(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.VPCs, resp.Count, nil
+	})
+	if err != nil {
+		log.Printf("Failed to list VPCs due to %v", err)
+		return nil, err
+	}
+	return vpcs, nil
+}
+
+// CreateVpc creates a VPC with the configured offering and CIDR, owned by the
+// account of the domain.
+func CreateVpc(cs *cloudstack.CloudStackClient, cfg *config.Config, zoneId string, domainId string, account string) (*cloudstack.CreateVPCResponse, error) {
+	vpcName := cfg.ResourceName(config.KindVpc)
+	p := cs.VPC.NewCreateVPCParams(cfg.VpcCidr, vpcName, vpcName, cfg.VpcOfferingId, zoneId)
+	p.SetDomainid(domainId)
+	p.SetAccount(account)
+
+	resp, err := cs.VPC.CreateVPC(p)
+	if err != nil {
+		log.Printf("Failed to create VPC due to: %v", err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func DeleteVpc(cs *cloudstack.CloudStackClient, vpcId string) (bool, error) {
+	p := cs.VPC.NewDeleteVPCParams(vpcId)
+	resp, err := cs.VPC.DeleteVPC(p)
+	if err != nil {
+		log.Printf("Failed to delete VPC with id %s due to %v", vpcId, err)
+		return false, err
+	}
+	return resp.Success, nil
+}
+
+// ListAclLists lists the network ACL lists of the VPC, leaving out the default
+// ones which are shared by all the VPCs.
+func ListAclLists(cs *cloudstack.CloudStackClient, vpcId string) ([]*cloudstack.NetworkACLList, error) {
+	p := cs.NetworkACL.NewListNetworkACLListsParams()
+	p.SetVpcid(vpcId)
+	resp, err := cs.NetworkACL.ListNetworkACLLists(p)
+	if err != nil {
+		log.Printf("Failed to list the network ACL lists of VPC %s due to %v", vpcId, err)
+		return nil, err
+	}
+	var lists []*cloudstack.NetworkACLList
+	for _, list := range resp.NetworkACLLists {
+		if list.Vpcid == vpcId {
+			lists = append(lists, list)
+		}
+	}
+	return lists, nil
+}
+
+// aclRules are the rules of the network ACL lists created by CreateAclList:
+// SSH and ping are allowed in, and all the traffic out.
+var aclRules = []struct {
+	trafficType string
+	protocol    string
+	port        int
+}{
+	{"Ingress", "tcp", 22},
+	{"Ingress", "icmp", 0},
+	{"Egress", "all", 0},
+}
+
+// NumAclRules returns the number of rules CreateAclRules creates.
+func NumAclRules() int {
+	return len(aclRules)
+}
+
+// CreateAclList creates a network ACL list in the VPC and returns its ID.
+func CreateAclList(cs *cloudstack.CloudStackClient, cfg *config.Config, vpcId string) (string, error) {
+	listName := cfg.ResourceName(config.KindAcl)
+	p := cs.NetworkACL.NewCreateNetworkACLListParams(listName, vpcId)
+	p.SetDescription(listName)
+	resp, err := cs.NetworkACL.CreateNetworkACLList(p)
+	if err != nil {
+		log.Printf("Failed to create network ACL list due to: %v", err)
+		return "", err
+	}
+	return resp.Id, nil
+}
+
+// CreateAclRules creates the rules of the network ACL list.
+func CreateAclRules(cs *cloudstack.CloudStackClient, aclId string) error {
+	for i, rule := range aclRules {
+		p := cs.NetworkACL.NewCreateNetworkACLParams(rule.protocol)
+		p.SetAclid(aclId)
+		p.SetNumber(i + 1)
+		p.SetAction("Allow")
+		p.SetTraffictype(rule.trafficType)
+		p.SetCidrlist([]string{"0.0.0.0/0"})
+		switch rule.protocol {
+		case "tcp":
+			p.SetStartport(rule.port)
+			p.SetEndport(rule.port)
+		case "icmp":
+			p.SetIcmptype(-1)
+			p.SetIcmpcode(-1)
+		}
+		if _, err := cs.NetworkACL.CreateNetworkACL(p); err != nil {
+			log.Printf("Failed to create network ACL rule in list %s due to: %v", aclId, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// TierSubnet returns the gateway and netmask of the tier with the given index,
+// the index-th /24 of the VPC CIDR.
+func TierSubnet(vpcCidr string, index int) (gateway string, netmask string, err error) {
+	_, cidr, err := net.ParseCIDR(vpcCidr)
+	if err != nil || cidr.IP.To4() == nil {
+		return "", "", fmt.Errorf("invalid VPC CIDR %q", vpcCidr)
+	}
+	ones, _ := cidr.Mask.Size()
+	if ones > 24 || index >= 1<<(24-ones) {
+		return "", "", fmt.Errorf("tier %d does not fit in the VPC CIDR %s", index, vpcCidr)
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(cidr.IP.To4())+uint32(index)<<8+1)
+	return ip.String(), "255.255.255.0", nil
+}
+
+// CreateTier creates the tier with the given index in the VPC, owned by the
+// account of the VPC and using the network ACL list.
+func CreateTier(cs *cloudstack.CloudStackClient, cfg *config.Config, vpc *cloudstack.VPC, aclId string, index int) (*cloudstack.CreateNetworkResponse, error) {
+	gateway, netmask, err := TierSubnet(vpc.Cidr, index)
+	if err != nil {
+		return nil, err
+	}
+	tierName := cfg.ResourceName(config.KindTier)
+	p := cs.Network.NewCreateNetworkParams(tierName, cfg.VpcTierOfferingId, vpc.Zoneid)
+	p.SetDomainid(vpc.Domainid)
+	p.SetAccount(vpc.Account)
+	p.SetVpcid(vpc.Id)
+	p.SetAclid(aclId)
+	p.SetGateway(gateway)
+	p.SetNetmask(netmask)
+	p.SetDisplaytext(tierName)
+
+	resp, err := cs.Network.CreateNetwork(p)
+	if err != nil {
+		log.Printf("Failed to create VPC tier due to: %v", err)
+		return nil, err
+	}
+	return resp, nil
+}